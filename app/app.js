@@ -1,4 +1,5 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  await finishSSOLogin();
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -65,9 +66,13 @@ async function login() {
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
-
-    if (data.token) {
+    if (data.mfa_required) {
+      await finishMFALogin(data.mfa_token);
+    } else if (data.token) {
       localStorage.setItem('token', data.token);
+    }
+
+    if (localStorage.getItem('token')) {
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -79,6 +84,70 @@ async function login() {
   }
 }
 
+function loginWithSSO() {
+  window.location.href = '/api/oidc/login';
+}
+
+// finishMFALogin asks for a second factor and exchanges it, along with the
+// token from the first step of the login, for a session.
+async function finishMFALogin(mfaToken) {
+  const code = prompt('Enter the code from your authenticator app, or a recovery code');
+  if (!code) {
+    return;
+  }
+  // recovery codes are longer than the 6 digit TOTP codes
+  const body = /^\d{6}$/.test(code.trim())
+    ? { mfa_token: mfaToken, code: code.trim() }
+    : { mfa_token: mfaToken, recovery_code: code.trim() };
+
+  const res = await fetch('/api/login/2fa', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  localStorage.setItem('token', data.token);
+}
+
+// finishSSOLogin exchanges the code single sign-on redirects back with for a
+// session, asking for a second factor first if the account has 2FA.
+async function finishSSOLogin() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const code = params.get('sso_code');
+  const mfaToken = params.get('mfa_token');
+  if (!code && !mfaToken) {
+    return;
+  }
+  // the codes are single-use, so don't leave them in the address bar or history
+  history.replaceState(null, '', window.location.pathname);
+
+  try {
+    if (mfaToken) {
+      await finishMFALogin(mfaToken);
+      return;
+    }
+    const res = await fetch('/api/oidc/session', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ code }),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to login: ${data.error}`);
+    }
+    localStorage.setItem('token', data.token);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
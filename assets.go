@@ -1,7 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +22,114 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// maxBlobCreateAttempts bounds how often recordNewBlob retries when the blob
+// it lost a race to is released before it can be shared.
+const maxBlobCreateAttempts = 3
+
+// newBlobKeySuffix makes the key of each stored copy of some bytes unique, so
+// that storing them again never writes to an object that a release of an
+// earlier copy is about to delete.
+func newBlobKeySuffix() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "-" + hex.EncodeToString(b), nil
+}
+
+// recordNewBlob records media just stored under blob.Key with a reference for
+// the caller. If a concurrent upload of the same bytes recorded its copy
+// first, that copy is shared instead and discard is called to delete ours.
+func (cfg *apiConfig) recordNewBlob(blob database.Blob, discard func()) (database.Blob, error) {
+	for range maxBlobCreateAttempts {
+		created, err := cfg.db.CreateBlob(blob)
+		if err != nil {
+			discard()
+			return database.Blob{}, err
+		}
+		if created {
+			return blob, nil
+		}
+		existing, err := cfg.db.AcquireBlob(blob.Namespace, blob.Hash, blob.MediaType)
+		if err != nil {
+			discard()
+			return database.Blob{}, err
+		}
+		if existing.Key != "" {
+			discard()
+			return existing, nil
+		}
+	}
+	discard()
+	return database.Blob{}, errors.New("blob kept being released before it could be shared")
+}
+
+// deleteVideoAssets releases a deleted video's media, removing it from S3 and
+// the assets directory unless other videos share it. Nothing references the
+// video anymore, so failures are logged rather than returned.
+func (cfg *apiConfig) deleteVideoAssets(ctx context.Context, video database.Video) {
+	if video.VideoURL != nil {
+		cfg.releaseVideoObject(ctx, video.ID, *video.VideoURL)
+	}
+	if video.ThumbnailURL != nil {
+		cfg.releaseThumbnail(video.ID, *video.ThumbnailURL)
+	}
+}
+
+// releaseVideoObject drops the video's reference to the S3 object at
+// videoURL, deleting the object if that was the last one.
+func (cfg *apiConfig) releaseVideoObject(ctx context.Context, videoID uuid.UUID, videoURL string) {
+	// finish cleaning up even if the client goes away
+	ctx = context.WithoutCancel(ctx)
+
+	key, ok := strings.CutPrefix(videoURL, "https://"+cfg.s3CfDistribution+"/")
+	if !ok {
+		return
+	}
+	unreferenced, err := cfg.db.ReleaseBlob(key)
+	if err != nil {
+		log.Printf("Couldn't release S3 object %s of video %s: %v", key, videoID, err)
+		return
+	}
+	if !unreferenced {
+		return
+	}
+	cfg.deleteVideoObject(ctx, videoID, key)
+}
+
+// deleteVideoObject deletes the S3 object at key that held a video's media,
+// logging failures.
+func (cfg *apiConfig) deleteVideoObject(ctx context.Context, videoID uuid.UUID, key string) {
+	// finish cleaning up even if the client goes away
+	ctx = context.WithoutCancel(ctx)
+
+	_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		log.Printf("Couldn't delete S3 object %s of video %s: %v", key, videoID, err)
+	}
+}
+
+// releaseThumbnail drops the video's reference to the thumbnail at
+// thumbnailURL, deleting the file if that was the last one.
+func (cfg *apiConfig) releaseThumbnail(videoID uuid.UUID, thumbnailURL string) {
+	_, key, ok := strings.Cut(thumbnailURL, "/assets/")
+	if !ok || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return
+	}
+	unreferenced, err := cfg.db.ReleaseBlob(key)
+	if err != nil {
+		log.Printf("Couldn't release thumbnail %s of video %s: %v", key, videoID, err)
+		return
+	}
+	if !unreferenced {
+		return
+	}
+	err = os.Remove(filepath.Join(cfg.assetsRoot, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Couldn't delete thumbnail %s of video %s: %v", key, videoID, err)
+	}
+}
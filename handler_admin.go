@@ -0,0 +1,344 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const adminAuditLogLimit = 200
+
+// requireAdmin returns the admin placed in the context by adminMiddleware,
+// responding with an error and returning false if there isn't one.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	admin, ok := principalFromContext(r.Context())
+	if !ok || admin.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+		return principal{}, false
+	}
+	return admin, true
+}
+
+// auditAdminAction records an admin action in the server log and the audit
+// log. The action has already happened, so a failure to record it is logged
+// rather than reported to the admin.
+func (cfg *apiConfig) auditAdminAction(admin principal, action, target, detail string) {
+	log.Printf("admin %s (%s): %s %s %s", admin.Email, admin.UserID, action, target, detail)
+	err := cfg.db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+		ActorID: &admin.UserID,
+		Action:  action,
+		Target:  target,
+		Detail:  detail,
+	})
+	if err != nil {
+		log.Printf("Couldn't record admin action %s on %s: %v", action, target, err)
+	}
+}
+
+type adminUser struct {
+	ID         uuid.UUID         `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Email      string            `json:"email"`
+	Role       database.UserRole `json:"role"`
+	DisabledAt *time.Time        `json:"disabled_at"`
+}
+
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "list_users", "users", "")
+
+	response := make([]adminUser, 0, len(users))
+	for _, user := range users {
+		response = append(response, adminUser{
+			ID:         user.ID,
+			CreatedAt:  user.CreatedAt,
+			UpdatedAt:  user.UpdatedAt,
+			Email:      user.Email,
+			Role:       user.Role,
+			DisabledAt: user.DisabledAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// getAdminTargetUser loads the user named by the request path, responding with
+// an error and returning false if there isn't one.
+func (cfg *apiConfig) getAdminTargetUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == admin.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't disable your own account", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := cfg.db.SetUserDisabled(user.ID, &now); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable user", err)
+		return
+	}
+	// a disabled account shouldn't be able to refresh its way back in
+	if err := cfg.db.RevokeUserRefreshTokens(user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke user sessions", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "disable_user", "user:"+user.ID.String(), user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.SetUserDisabled(user.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable user", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "enable_user", "user:"+user.ID.String(), user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.RevokeUserRefreshTokens(user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke user sessions", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "revoke_sessions", "user:"+user.ID.String(), user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminVideoGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Video
+		Shares []database.VideoShare `json:"shares"`
+	}
+
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	shares, err := cfg.db.GetVideoShares(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+		return
+	}
+	video, err = cfg.withMediaURLs(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "inspect_video", "video:"+video.ID.String(), "")
+
+	respondWithJSON(w, http.StatusOK, response{
+		Video:  video,
+		Shares: shares,
+	})
+}
+
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteVideo(videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	cfg.deleteVideoAssets(r.Context(), video)
+	cfg.auditAdminAction(admin, "delete_video", "video:"+video.ID.String(), video.Title)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type storageUsage struct {
+	Prefix  string `json:"prefix"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+func (cfg *apiConfig) handlerAdminStorage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		S3     []storageUsage `json:"s3"`
+		Assets storageUsage   `json:"assets"`
+	}
+
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	// group objects by workspace, or by top-level folder for personal media
+	usageByPrefix := map[string]*storageUsage{}
+	prefixes := []string{}
+	paginator := s3.NewListObjectsV2Paginator(cfg.s3Client, &s3.ListObjectsV2Input{
+		Bucket: &cfg.s3Bucket,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't list S3 objects", err)
+			return
+		}
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+			prefix := usagePrefix(*object.Key)
+			usage, ok := usageByPrefix[prefix]
+			if !ok {
+				usage = &storageUsage{Prefix: prefix}
+				usageByPrefix[prefix] = usage
+				prefixes = append(prefixes, prefix)
+			}
+			usage.Objects++
+			if object.Size != nil {
+				usage.Bytes += *object.Size
+			}
+		}
+	}
+
+	assets := storageUsage{Prefix: cfg.assetsRoot}
+	err := filepath.WalkDir(cfg.assetsRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		assets.Objects++
+		assets.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't measure assets directory", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "view_storage", "storage", "")
+
+	resp := response{
+		S3:     make([]storageUsage, 0, len(prefixes)),
+		Assets: assets,
+	}
+	for _, prefix := range prefixes {
+		resp.S3 = append(resp.S3, *usageByPrefix[prefix])
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// usagePrefix returns the part of an object key that storage usage is grouped
+// by: "workspaces/<id>" for workspace media, otherwise the first path segment.
+func usagePrefix(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) >= 2 && parts[0] == "workspaces" {
+		return parts[0] + "/" + parts[1]
+	}
+	if len(parts) >= 2 {
+		return parts[0]
+	}
+	return ""
+}
+
+func (cfg *apiConfig) handlerAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := cfg.db.GetAuditLog(adminAuditLogLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit log", err)
+		return
+	}
+	cfg.auditAdminAction(admin, "view_audit_log", "audit_log", "")
+	respondWithJSON(w, http.StatusOK, entries)
+}
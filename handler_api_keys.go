@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeyNameLength = 100
+	// apiKeyDisplayLength is how much of a key is kept in the clear so users
+	// can tell their keys apart.
+	apiKeyDisplayLength = len(auth.APIKeyPrefix) + 8
+)
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever returned here; the server keeps just its hash.
+		Key string `json:"key"`
+	}
+
+	// neither API keys nor tokens minted for integrations may mint API keys,
+	// which would outlive them
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name must be between 1 and %d characters", maxAPIKeyNameLength), nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+		if scope == auth.ScopeAdmin && caller.Role != database.UserRoleAdmin {
+			respondWithError(w, http.StatusForbidden, "Only admins can create keys with the admin scope", nil)
+			return
+		}
+		// a key can only narrow what the caller may already do
+		if !caller.hasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Credentials lack the "+scope+" scope", nil)
+			return
+		}
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate API key", err)
+		return
+	}
+
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  caller.UserID,
+		Name:    name,
+		Prefix:  key[:apiKeyDisplayLength],
+		KeyHash: auth.HashAPIKey(key),
+		Scopes:  params.Scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerAPIKeysRetrieve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(caller.UserID, keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Couldn't find active API key", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -9,17 +9,23 @@ import (
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+// refreshTokenTTL is how long a refresh token stays valid. Each refresh issues
+// a new one, so a session lasts as long as it's used at least this often.
+const refreshTokenTTL = time.Hour * 24 * 60
+
+// mfaChallenge is the login response for users with 2FA enabled. The token
+// is exchanged for a session at /api/login/2fa along with a code.
+type mfaChallenge struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
-
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -28,50 +34,94 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	accountKey, ipKey := loginThrottleKeys(r, params.Email)
+	if !cfg.checkLoginLockout(w, accountKey, ipKey) {
+		return
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
 		return
 	}
 
 	match, err := auth.CheckPasswordHash(params.Password, user.Password)
-	if err != nil {
+	if err != nil || !match {
+		if err := cfg.recordLoginFailure(accountKey, ipKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
-	if !match {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
+	totp, err := cfg.db.GetUserTOTP(user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.Enabled() {
+		// failures aren't cleared until the second factor is checked too
+		mfaToken, err := cfg.issueUserToken(user.ID, database.UserTokenMFALogin, mfaChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA token", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaChallenge{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresAt:   time.Now().UTC().Add(mfaChallengeTTL),
+		})
 		return
 	}
 
+	if err := cfg.db.ClearLoginFailures(accountKey); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, user)
+}
+
+// respondWithNewSession starts a login session for user and responds with its
+// access and refresh tokens.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		publicUser
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+	session, err := cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		UserAgent: clientUserAgent(r),
+		IPAddress: clientIP(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
 		return
 	}
 
+	accessToken, err := cfg.makeAccessToken(user.ID, session.FamilyID, sessionScopes, cfg.accessTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
+		publicUser:   newPublicUser(user),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	// oidcStateTTL is how long a user has to finish signing in at the
+	// identity provider.
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie holds the login state in the browser that started the
+	// login, so that a callback URL can't be redeemed anywhere else.
+	oidcStateCookie = "tubely_oidc_state"
+	// ssoLoginCodeTTL is how long the web app has to exchange the code it's
+	// redirected back with for a session.
+	ssoLoginCodeTTL = time.Minute
+)
+
+// handlerOIDCLogin starts single sign-on by redirecting to the identity
+// provider.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, oidc.PKCEChallenge(verifier))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider", err)
+		return
+	}
+
+	err = cfg.db.CreateOIDCState(database.OIDCState{
+		State:        state,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateTTL),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login state", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		// Lax still sends the cookie on the provider's top-level redirect back
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes single sign-on: it checks the identity
+// provider's response and finds, links or creates the user. The browser is
+// then sent back to the web app with a single-use code, which
+// handlerOIDCSession exchanges for a session just like a password login, or
+// with an MFA token for /api/login/2fa if the user has 2FA enabled.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider refused login: "+errCode, nil)
+		return
+	}
+
+	// the state must come back to the browser the login was started in,
+	// otherwise anyone could finish a login with a leaked callback URL, or log
+	// a victim into the attacker's account
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login wasn't started from this browser", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/oidc/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	state, err := cfg.db.ConsumeOIDCState(query.Get("state"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get login state", err)
+		return
+	}
+	if state.State == "" || time.Now().After(state.ExpiresAt) {
+		respondWithError(w, http.StatusBadRequest, "Login attempt is unknown or has expired", nil)
+		return
+	}
+
+	rawIDToken, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't exchange authorization code", err)
+		return
+	}
+	claims, err := cfg.oidcProvider.VerifyIDToken(r.Context(), rawIDToken, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate ID token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByIdentity(cfg.oidcProvider.Issuer(), claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		linked, ok := cfg.linkOIDCIdentity(w, claims)
+		if !ok {
+			return
+		}
+		user = &linked
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	// the identity provider only stands in for the password, so accounts
+	// with 2FA still need their second factor
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	// the fragment isn't sent to servers or in Referer headers
+	if totp.Enabled() {
+		mfaToken, err := cfg.issueUserToken(user.ID, database.UserTokenMFALogin, mfaChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA token", err)
+			return
+		}
+		http.Redirect(w, r, "/app/#"+url.Values{"mfa_token": {mfaToken}}.Encode(), http.StatusFound)
+		return
+	}
+	code, err := cfg.issueUserToken(user.ID, database.UserTokenSSOLogin, ssoLoginCodeTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login code", err)
+		return
+	}
+	http.Redirect(w, r, "/app/#"+url.Values{"sso_code": {code}}.Encode(), http.StatusFound)
+}
+
+// handlerOIDCSession exchanges the code a single sign-on login redirected the
+// web app with for a session.
+func (cfg *apiConfig) handlerOIDCSession(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	token, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Code), database.UserTokenSSOLogin)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get login code", err)
+		return
+	}
+	if token.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Login code is invalid or expired", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login code is invalid or expired", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, *user)
+}
+
+// linkOIDCIdentity links a first-time single sign-on user to the account with
+// their email address, creating one if needed, and responds with an error and
+// returns false if it can't. Only emails the provider has verified are trusted,
+// since anyone could otherwise claim an account. Existing accounts are only
+// linked once their owner has verified the address with us too; otherwise
+// whoever registered it first, perhaps to squat on it, would share the
+// account with the SSO user.
+func (cfg *apiConfig) linkOIDCIdentity(w http.ResponseWriter, claims oidc.Claims) (database.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Identity provider hasn't verified your email address", nil)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.GetUserByEmail(claims.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user.ID != uuid.Nil && user.EmailVerifiedAt == nil {
+		respondWithError(w, http.StatusConflict, "An account with this email address exists but hasn't verified it; sign in with its password and verify the address before using single sign-on", nil)
+		return database.User{}, false
+	}
+	if user.ID == uuid.Nil {
+		// provisioned users have no password and can only sign in through SSO
+		created, err := cfg.db.CreateUser(database.CreateUserParams{
+			Email: claims.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+			return database.User{}, false
+		}
+		// the provider vouched for the address
+		if _, err := cfg.db.SetUserEmailVerified(created.ID, created.Email); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+			return database.User{}, false
+		}
+		user = *created
+		log.Printf("Provisioned user %s from %s", user.Email, cfg.oidcProvider.Issuer())
+	}
+
+	err = cfg.db.CreateUserIdentity(database.UserIdentity{
+		Issuer:  cfg.oidcProvider.Issuer(),
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link identity", err)
+		return database.User{}, false
+	}
+	return user, true
+}
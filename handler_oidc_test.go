@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+// newTestOIDC points cfg at a mock identity provider that signs in user.
+func newTestOIDC(t *testing.T, cfg *apiConfig, user oidctest.User) *oidctest.Server {
+	t.Helper()
+	idp := oidctest.NewServer(t, "tubely")
+	idp.SetUser(user)
+	cfg.oidcProvider = oidc.NewProvider(oidc.Config{
+		Issuer:      idp.URL,
+		ClientID:    "tubely",
+		RedirectURL: "http://tubely.test/api/oidc/callback",
+		Scopes:      []string{"email"},
+	})
+	return idp
+}
+
+// startSSOLogin starts a login and signs in at the identity provider,
+// returning the callback URL it redirects back to and the state cookie set
+// for the browser.
+func startSSOLogin(t *testing.T, cfg *apiConfig) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(rec, httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: got status %d: %s", rec.Code, rec.Body)
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login: got state cookie %v, want an HttpOnly, SameSite=Lax one", stateCookie)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %s", resp.Status)
+	}
+	return resp.Header.Get("Location"), stateCookie
+}
+
+// finishSSOLogin delivers the identity provider's redirect to the callback
+// with cookie, if any.
+func finishSSOLogin(t *testing.T, cfg *apiConfig, callbackURL string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", callbackURL, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(rec, req)
+	return rec
+}
+
+// appFragment returns the parameters the callback sent the web app.
+func appFragment(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: got status %d: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Path != "/app/" {
+		t.Fatalf("callback redirected to %s, want the web app", location)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func TestOIDCLogin(t *testing.T) {
+	cfg := newTestConfig(t)
+	newTestOIDC(t, cfg, oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startSSOLogin(t, cfg)
+	code := appFragment(t, finishSSOLogin(t, cfg, callbackURL, cookie)).Get("sso_code")
+	if code == "" {
+		t.Fatal("callback didn't hand the web app a login code")
+	}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.handlerOIDCSession), "POST", "/api/oidc/session", "", map[string]string{"code": code})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("session: got status %d: %s", rec.Code, rec.Body)
+	}
+	var session struct {
+		Email string `json:"email"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.Email != "alice@example.com" || session.Token == "" {
+		t.Errorf("got session %+v, want one for alice@example.com", session)
+	}
+
+	// login codes are single-use
+	rec = doJSON(t, http.HandlerFunc(cfg.handlerOIDCSession), "POST", "/api/oidc/session", "", map[string]string{"code": code})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("reusing the code: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	cfg := newTestConfig(t)
+	newTestOIDC(t, cfg, oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startSSOLogin(t, cfg)
+	otherURL, _ := startSSOLogin(t, cfg)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"no cookie", nil},
+		{"another login's cookie", &http.Cookie{Name: oidcStateCookie, Value: "not-the-state"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := finishSSOLogin(t, cfg, otherURL, tt.cookie)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+		})
+	}
+
+	// the browser that started the login can still finish it
+	if code := appFragment(t, finishSSOLogin(t, cfg, callbackURL, cookie)).Get("sso_code"); code == "" {
+		t.Error("callback didn't hand the web app a login code")
+	}
+}
+
+func TestOIDCLoginDoesNotLinkUnverifiedAccount(t *testing.T) {
+	cfg := newTestConfig(t)
+	signUpAndLogIn(t, cfg, "alice@example.com", "squatter's password")
+	newTestOIDC(t, cfg, oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startSSOLogin(t, cfg)
+	rec := finishSSOLogin(t, cfg, callbackURL, cookie)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.SetUserEmailVerified(alice.ID, alice.Email); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetPendingUserTOTP(alice.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.EnableUserTOTP(alice.ID, 0, []string{auth.HashRecoveryCode("recovery")}); err != nil {
+		t.Fatal(err)
+	}
+	newTestOIDC(t, cfg, oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startSSOLogin(t, cfg)
+	fragment := appFragment(t, finishSSOLogin(t, cfg, callbackURL, cookie))
+	if fragment.Has("sso_code") {
+		t.Fatal("callback handed out a session code without a second factor")
+	}
+	mfaToken := fragment.Get("mfa_token")
+	if mfaToken == "" {
+		t.Fatal("callback didn't ask for a second factor")
+	}
+
+	body := map[string]string{"mfa_token": mfaToken, "recovery_code": "recovery"}
+	rec := doJSON(t, http.HandlerFunc(cfg.handlerLoginMFA), "POST", "/api/login/2fa", "", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("2fa: got status %d: %s", rec.Code, rec.Body)
+	}
+}
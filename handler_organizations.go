@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const maxOrganizationNameLength = 100
+
+// getOrganizationRole loads the organization named by the request path and the
+// caller's role in it, responding with an error and returning false unless the
+// caller holds at least the required role.
+func (cfg *apiConfig) getOrganizationRole(w http.ResponseWriter, r *http.Request, userID uuid.UUID, required database.OrgRole) (database.Organization, database.OrgRole, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Organization{}, "", false
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return database.Organization{}, "", false
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Couldn't find organization", nil)
+		return database.Organization{}, "", false
+	}
+	if !role.AtLeast(required) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Only organization %ss can do that", required), nil)
+		return database.Organization{}, "", false
+	}
+
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return database.Organization{}, "", false
+	}
+	return org, role, true
+}
+
+func (cfg *apiConfig) handlerOrganizationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxOrganizationNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name must be between 1 and %d characters", maxOrganizationNameLength), nil)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, database.UserOrganization{
+		Organization: org,
+		Role:         database.OrgRoleOwner,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	orgs, err := cfg.db.GetUserOrganizations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (cfg *apiConfig) handlerOrganizationGet(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	org, role, ok := cfg.getOrganizationRole(w, r, userID, database.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, database.UserOrganization{
+		Organization: org,
+		Role:         role,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersRetrieve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	org, _, ok := cfg.getOrganizationRole(w, r, userID, database.OrgRoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string           `json:"email"`
+		Role  database.OrgRole `json:"role"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Role must be owner, admin, member or viewer", nil)
+		return
+	}
+
+	org, role, ok := cfg.getOrganizationRole(w, r, userID, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// admins manage members and viewers; only owners can touch owners and admins
+	currentRole, err := cfg.db.GetOrganizationRole(org.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return
+	}
+	if role != database.OrgRoleOwner && (params.Role.AtLeast(database.OrgRoleAdmin) || currentRole.AtLeast(database.OrgRoleAdmin)) {
+		respondWithError(w, http.StatusForbidden, "Only organization owners can manage owners and admins", nil)
+		return
+	}
+	if currentRole == database.OrgRoleOwner && params.Role != database.OrgRoleOwner {
+		owners, err := cfg.db.CountOrganizationOwners(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count organization owners", err)
+			return
+		}
+		if owners <= 1 {
+			respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+			return
+		}
+	}
+
+	// the response is the same whether or not the address has an account, so
+	// that adding members can't be used to find out who's signed up
+	if user.ID == uuid.Nil {
+		cfg.sendMail(mailer.Message{
+			To:      params.Email,
+			Subject: "You've been invited to Tubely",
+			Body: fmt.Sprintf(
+				"%s would like to add you to the %q workspace on Tubely.\n\nSign up with this email address, then ask them to add you again.\n",
+				caller.Email, org.Name,
+			),
+		})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := cfg.db.SetOrganizationMember(org.ID, user.ID, params.Role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set organization member", err)
+		return
+	}
+
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "You were added to a Tubely workspace",
+		Body:    fmt.Sprintf("%s added you to the %q workspace on Tubely as %s.\n", caller.Email, org.Name, params.Role),
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberRemove(w http.ResponseWriter, r *http.Request) {
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	// anyone may leave; removing someone else takes an admin
+	required := database.OrgRoleAdmin
+	if memberID == userID {
+		required = database.OrgRoleViewer
+	}
+	org, role, ok := cfg.getOrganizationRole(w, r, userID, required)
+	if !ok {
+		return
+	}
+
+	memberRole, err := cfg.db.GetOrganizationRole(org.ID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return
+	}
+	if memberRole == "" {
+		respondWithError(w, http.StatusNotFound, "That user isn't a member of this organization", nil)
+		return
+	}
+	if memberID != userID && role != database.OrgRoleOwner && memberRole.AtLeast(database.OrgRoleAdmin) {
+		respondWithError(w, http.StatusForbidden, "Only organization owners can remove owners and admins", nil)
+		return
+	}
+	if memberRole == database.OrgRoleOwner {
+		owners, err := cfg.db.CountOrganizationOwners(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count organization owners", err)
+			return
+		}
+		if owners <= 1 {
+			respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.RemoveOrganizationMember(org.ID, memberID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove organization member", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestOrganizationMemberSetDoesNotRevealAccounts(t *testing.T) {
+	cfg := newTestConfig(t)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/organizations/{orgID}/members", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerOrganizationMemberSet))
+	tokens := map[string]string{}
+	users := map[string]database.User{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		email := name + "@example.com"
+		tokens[name] = signUpAndLogIn(t, cfg, email, "correct horse")
+		user, err := cfg.db.GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+	org, err := cfg.db.CreateOrganization("Acme", users["alice"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetOrganizationMember(org.ID, users["carol"].ID, database.OrgRoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/api/organizations/" + org.ID.String() + "/members"
+	tests := []struct {
+		name   string
+		caller string
+		role   database.OrgRole
+		want   int
+	}{
+		{"owner adds a member", "alice", database.OrgRoleMember, http.StatusAccepted},
+		{"admin can't make admins", "carol", database.OrgRoleAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := func(email string) map[string]string {
+				return map[string]string{"email": email, "role": string(tt.role)}
+			}
+			registered := doJSON(t, mux, "PUT", target, tokens[tt.caller], body("bob@example.com"))
+			unknown := doJSON(t, mux, "PUT", target, tokens[tt.caller], body("nobody@example.com"))
+			if registered.Code != tt.want {
+				t.Errorf("registered address: got status %d, want %d: %s", registered.Code, tt.want, registered.Body)
+			}
+			if unknown.Code != registered.Code || unknown.Body.String() != registered.Body.String() {
+				t.Errorf("unknown address got %d %q, registered got %d %q", unknown.Code, unknown.Body, registered.Code, registered.Body)
+			}
+		})
+	}
+
+	role, err := cfg.db.GetOrganizationRole(org.ID, users["bob"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != database.OrgRoleMember {
+		t.Errorf("got bob's role %q, want %q", role, database.OrgRoleMember)
+	}
+}
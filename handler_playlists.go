@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxPlaylistTitleLength = 200
+
+// getOwnedPlaylist loads the playlist named by the request path and checks that
+// it belongs to userID, responding with an error and returning false otherwise.
+func (cfg *apiConfig) getOwnedPlaylist(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil || playlist.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+func validatePlaylistTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", errors.New("title can't be empty")
+	}
+	if utf8.RuneCountInString(title) > maxPlaylistTitleLength {
+		return "", fmt.Errorf("title can't be longer than %d characters", maxPlaylistTitleLength)
+	}
+	return title, nil
+}
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	title, err := validatePlaylistTitle(params.Title)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{
+		Title:       title,
+		Description: params.Description,
+		UserID:      userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	playlists, err := cfg.db.GetPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if params.Title != nil {
+		title, err := validatePlaylistTitle(*params.Title)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		playlist.Title = title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+
+	playlist.UpdatedAt = time.Now()
+	if err := cfg.db.UpdatePlaylist(playlist); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeletePlaylist(playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID uuid.UUID `json:"video_id"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't add this video to a playlist", nil)
+		return
+	}
+
+	if err := cfg.db.AddPlaylistVideo(playlist.ID, video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+
+	playlist, err = cfg.db.GetPlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.RemovePlaylistVideo(playlist.ID, videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// the new order must be a permutation of the playlist's current videos
+	current := make(map[uuid.UUID]bool, len(playlist.VideoIDs))
+	for _, videoID := range playlist.VideoIDs {
+		current[videoID] = true
+	}
+	if len(params.VideoIDs) != len(current) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist exactly once", nil)
+		return
+	}
+	for _, videoID := range params.VideoIDs {
+		if !current[videoID] {
+			respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist exactly once", nil)
+			return
+		}
+		delete(current, videoID)
+	}
+
+	if err := cfg.db.ReorderPlaylist(playlist.ID, params.VideoIDs); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+
+	playlist.VideoIDs = params.VideoIDs
+	respondWithJSON(w, http.StatusOK, playlist)
+}
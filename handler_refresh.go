@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,24 +22,57 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-	)
+	rotated, err := cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		UserAgent: clientUserAgent(r),
+		IPAddress: clientIP(r),
+	})
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		// someone is replaying a token that was already exchanged, so the
+		// session may be stolen: both parties have to log in again
+		log.Printf("Refresh token reuse detected; revoked session")
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used; session revoked", err)
+		return
+	}
+	if errors.Is(err, database.ErrRefreshTokenInvalid) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is invalid or expired", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(rotated.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	accessToken, err := cfg.makeAccessToken(user.ID, rotated.FamilyID, sessionScopes, cfg.accessTokenTTL)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxUserAgentLength caps how much of the User-Agent header is stored with a
+// session.
+const maxUserAgentLength = 512
+
+// clientIP returns the address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func clientUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+func (cfg *apiConfig) handlerSessionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		database.Session
+		// Current marks the session the request was made from.
+		Current bool `json:"current"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := cfg.db.GetSessions(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+
+	response := make([]session, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, session{
+			Session: s,
+			Current: *caller.SessionID == s.ID,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := cfg.db.RevokeSession(caller.UserID, sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Couldn't find active session", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeOthers logs the user out everywhere except the session
+// making the request.
+func (cfg *apiConfig) handlerSessionsRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.RevokeOtherSessions(caller.UserID, *caller.SessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
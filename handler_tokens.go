@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// makeAccessToken signs an access token for one of the user's sessions.
+func (cfg *apiConfig) makeAccessToken(userID, sessionID uuid.UUID, scopes []string, expiresIn time.Duration) (string, error) {
+	return auth.MakeJWT(auth.AccessToken{
+		UserID:    userID,
+		SessionID: sessionID,
+		Audience:  cfg.jwtAudience,
+		Scopes:    scopes,
+	}, cfg.jwtKeys, expiresIn)
+}
+
+// handlerTokenCreate mints an access token limited to some of the caller's
+// scopes, and optionally a shorter lifetime, to hand to an integration. It is
+// tied to the caller's session, so revoking the session revokes it too, and
+// marked as delegated so it can't mint more tokens or change account
+// security settings.
+func (cfg *apiConfig) handlerTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Scopes []string `json:"scopes"`
+		// ExpiresInSeconds defaults to, and can't exceed, the access token
+		// lifetime.
+		ExpiresInSeconds int `json:"expires_in_seconds"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		Scopes    []string  `json:"scopes"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+		// a token can only narrow what the caller may already do
+		if !caller.hasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Credentials lack the "+scope+" scope", nil)
+			return
+		}
+	}
+
+	expiresIn := cfg.accessTokenTTL
+	if params.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_seconds must be positive", nil)
+		return
+	}
+	if params.ExpiresInSeconds > 0 {
+		expiresIn = min(expiresIn, time.Duration(params.ExpiresInSeconds)*time.Second)
+	}
+
+	token, err := auth.MakeJWT(auth.AccessToken{
+		UserID:    caller.UserID,
+		SessionID: *caller.SessionID,
+		Audience:  cfg.jwtAudience,
+		Scopes:    params.Scopes,
+		Delegated: true,
+	}, cfg.jwtKeys, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		Scopes:    params.Scopes,
+		ExpiresAt: time.Now().UTC().Add(expiresIn),
+	})
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter their second factor
+	// after entering their password.
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	// totpIssuer names Tubely in authenticator apps.
+	totpIssuer = "Tubely"
+)
+
+// checkSecondFactor reports whether code is a current TOTP code, or
+// recoveryCode an unused recovery code, for the enrollment, using it up if so.
+func (cfg *apiConfig) checkSecondFactor(totp database.UserTOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok, err := auth.ValidateTOTP(totp.Secret, code, time.Now())
+		if err != nil || !ok {
+			return false, err
+		}
+		return cfg.db.UseTOTPStep(totp.UserID, step)
+	}
+	if recoveryCode != "" {
+		return cfg.db.ConsumeRecoveryCode(totp.UserID, auth.HashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+// newRecoveryCodes generates a fresh set of recovery codes and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes = make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// handlerLoginMFA finishes a login started with handlerLogin by checking the
+// user's second factor.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mfaTokenHash := auth.HashToken(params.MFAToken)
+	challenge, err := cfg.db.GetUserToken(mfaTokenHash, database.UserTokenMFALogin)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA token", err)
+		return
+	}
+	if challenge.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "MFA token is invalid or expired", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(challenge.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "MFA token is invalid or expired", nil)
+		return
+	}
+
+	// codes are easier to guess than passwords, so they count towards the
+	// same lockouts
+	accountKey, ipKey := loginThrottleKeys(r, user.Email)
+	if !cfg.checkLoginLockout(w, accountKey, ipKey) {
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	ok := false
+	if totp.Enabled() {
+		ok, err = cfg.checkSecondFactor(totp, params.Code, params.RecoveryCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return
+		}
+	}
+	if !ok {
+		if err := cfg.recordLoginFailure(accountKey, ipKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Incorrect code", nil)
+		return
+	}
+
+	challenge, err = cfg.db.ConsumeUserToken(mfaTokenHash, database.UserTokenMFALogin)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem MFA token", err)
+		return
+	}
+	if challenge.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "MFA token is invalid or expired", nil)
+		return
+	}
+	if err := cfg.db.ClearLoginFailures(accountKey); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, *user)
+}
+
+func (cfg *apiConfig) handlerTwoFactorRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Enabled                bool       `json:"enabled"`
+		EnabledAt              *time.Time `json:"enabled_at"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	remaining := 0
+	if totp.Enabled() {
+		remaining, err = cfg.db.CountRecoveryCodes(caller.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count recovery codes", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Enabled:                totp.Enabled(),
+		EnabledAt:              totp.EnabledAt,
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+// handlerTwoFactorEnroll starts enrolling an authenticator app. 2FA isn't
+// enabled until the user confirms a code from the app.
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUser(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+	if user.Password == "" {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication protects password logins; set a password first", nil)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.Enabled() {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	if err := cfg.db.SetPendingUserTOTP(user.ID, secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// handlerTwoFactorConfirm enables 2FA once the user proves their app
+// generates the right codes, and responds with their recovery codes. They
+// aren't stored in plain text, so this is the only time they are shown.
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Start enrolling before confirming", nil)
+		return
+	}
+	if totp.Enabled() {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	// wrong codes count towards login lockouts, so that a stolen session
+	// can't be used to guess them
+	accountKey, ipKey := loginThrottleKeys(r, caller.Email)
+	if !cfg.checkLoginLockout(w, accountKey, ipKey) {
+		return
+	}
+
+	step, ok, err := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		if err := cfg.recordLoginFailure(accountKey, ipKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Incorrect code", nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+	if err := cfg.db.EnableUserTOTP(caller.UserID, step, hashes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+// handlerRecoveryCodesRegenerate replaces the user's recovery codes, for when
+// they've used up or lost them. It takes a current code from their app.
+func (cfg *apiConfig) handlerRecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if !totp.Enabled() {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+
+	// as when confirming enrollment, wrong codes count towards lockouts
+	accountKey, ipKey := loginThrottleKeys(r, caller.Email)
+	if !cfg.checkLoginLockout(w, accountKey, ipKey) {
+		return
+	}
+
+	ok, err = cfg.checkSecondFactor(totp, params.Code, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		if err := cfg.recordLoginFailure(accountKey, ipKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Incorrect code", nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+	if err := cfg.db.ReplaceRecoveryCodes(caller.UserID, hashes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+// handlerTwoFactorDisable turns off 2FA. A stolen session shouldn't be enough
+// to weaken the account, so the user must enter their password and a second
+// factor again.
+func (cfg *apiConfig) handlerTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if !totp.Enabled() {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+
+	if !cfg.reauthenticate(w, r, *user, params.Password, params.Code, params.RecoveryCode, "") {
+		return
+	}
+
+	if err := cfg.db.DeleteUserTOTP(user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
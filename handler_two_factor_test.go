@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestTwoFactorCodeGuessesAreLockedOut(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		enabled bool
+		// wrongStatus is the response to a wrong code before the lockout
+		wrongStatus int
+	}{
+		{"confirm enrollment", "/api/users/me/2fa/confirm", false, http.StatusBadRequest},
+		{"regenerate recovery codes", "/api/users/me/2fa/recovery_codes", true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+			alice, err := cfg.db.GetUserByEmail("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err := auth.GenerateTOTPSecret()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.db.SetPendingUserTOTP(alice.ID, secret); err != nil {
+				t.Fatal(err)
+			}
+			if tt.enabled {
+				if err := cfg.db.EnableUserTOTP(alice.ID, 0, []string{auth.HashRecoveryCode("recovery")}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mux := http.NewServeMux()
+			mux.Handle("POST /api/users/me/2fa/confirm", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerTwoFactorConfirm))
+			mux.Handle("POST /api/users/me/2fa/recovery_codes", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerRecoveryCodesRegenerate))
+
+			// no code is ever 7 digits long
+			body := map[string]string{"code": "0000000"}
+			for i := range cfg.loginLimits.MaxAccountFailures {
+				if rec := doJSON(t, mux, "POST", tt.target, token, body); rec.Code != tt.wrongStatus {
+					t.Fatalf("guess %d: got status %d, want %d: %s", i+1, rec.Code, tt.wrongStatus, rec.Body)
+				}
+			}
+			rec := doJSON(t, mux, "POST", tt.target, token, body)
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("got status %d once locked out, want %d: %s", rec.Code, http.StatusTooManyRequests, rec.Body)
+			}
+		})
+	}
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"bytes"
+	"encoding/hex"
 	"fmt"
-	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -12,7 +11,7 @@ import (
 	"path/filepath"
 	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -24,39 +23,45 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	// authentication
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
 		return
 	}
+	userID := caller.UserID
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
-	// 10MB max
-	const maxMemory = 10 << 20
-	if err := r.ParseMultipartForm(maxMemory); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form", err)
+	// check if user can edit video in the active workspace
+	ws, ok := cfg.getActiveWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionEdit)
+	if !ok {
+		return
+	}
+	if !ws.contains(video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video in this workspace", nil)
 		return
 	}
 
 	// get thumbnail
-	file, header, err := r.FormFile("thumbnail")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't get thumbnail file", err)
+	checksums, ok := parseUploadChecksums(w, r)
+	if !ok {
+		return
+	}
+	part, ok := openUploadPart(w, r, "thumbnail", cfg.uploadLimits.Thumbnail)
+	if !ok {
 		return
 	}
-	defer func(file multipart.File) {
-		_ = file.Close()
-	}(file)
+	defer func(part *multipart.Part) {
+		_ = part.Close()
+	}(part)
 
 	// get media type
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't parse thumbnail media type", err)
 		return
@@ -73,55 +78,82 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// read file
-	data, err := io.ReadAll(file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail file", err)
+	// read file, which the upload limit keeps small
+	var buf bytes.Buffer
+	uploaded, ok := copyUploadPart(w, &buf, part, cfg.uploadLimits.Thumbnail)
+	if !ok {
 		return
 	}
-
-	// check if user owns video
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+	data := buf.Bytes()
+	if !checksums.verify(w, uploaded) {
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You can't upload thumbnail for this video", nil)
+	if !cfg.scanUpload(w, r, video, "thumbnail", bytes.NewReader(data), uploaded.SHA256) {
 		return
 	}
 
-	// randomly generate thumbnail name
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate thumbnail name", err)
+	if !cfg.checkStorageQuota(w, video.UserID, int64(len(data))-video.ThumbnailSize, 0) {
 		return
 	}
-	thumbnailName := base64.RawURLEncoding.EncodeToString(randomBytes)
 
-	// save thumbnail to assets directory
-	thumbnailPath := filepath.Join(cfg.assetsRoot, fmt.Sprintf("%s%s", thumbnailName, extensions[0]))
-	create, err := os.Create(thumbnailPath)
+	// reuse the stored thumbnail if the same bytes were uploaded to this
+	// workspace before
+	blob, err := cfg.db.AcquireBlob(storagePrefix(video.OrganizationID), hex.EncodeToString(uploaded.SHA256), mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create thumbnail file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up stored thumbnail", err)
 		return
 	}
-	defer func() {
-		_ = create.Close()
-	}()
-	if _, err := create.Write(data); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't write thumbnail file", err)
-		return
+
+	// otherwise save it, named after its content, to the workspace's part of
+	// the assets directory
+	if blob.Key == "" {
+		suffix, err := newBlobKeySuffix()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate thumbnail key", err)
+			return
+		}
+		blob = database.Blob{
+			Namespace: storagePrefix(video.OrganizationID),
+			Hash:      hex.EncodeToString(uploaded.SHA256),
+			MediaType: mediaType,
+			Size:      int64(len(data)),
+			SHA256:    hex.EncodeToString(uploaded.SHA256),
+		}
+		blob.Key = blob.Namespace + blob.Hash + suffix + extensions[0]
+		thumbnailPath := filepath.Join(cfg.assetsRoot, filepath.FromSlash(blob.Key))
+		if err := os.MkdirAll(filepath.Dir(thumbnailPath), 0755); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create thumbnail directory", err)
+			return
+		}
+		if err := os.WriteFile(thumbnailPath, data, 0644); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't write thumbnail file", err)
+			return
+		}
+		blob, err = cfg.recordNewBlob(blob, func() {
+			_ = os.Remove(thumbnailPath)
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record stored thumbnail", err)
+			return
+		}
 	}
 
 	// update video metadata
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s%s", cfg.port, thumbnailName, extensions[0])
+	previousURL := video.ThumbnailURL
+	thumbnailURL := cfg.thumbnailURL(blob.Key)
 	video.ThumbnailURL = &thumbnailURL
+	video.ThumbnailSize = blob.Size
+	video.Status = database.VideoStatusActive
+	video.RejectionReason = nil
 	video.UpdatedAt = time.Now()
 	if err := cfg.db.UpdateVideo(video); err != nil {
+		cfg.releaseThumbnail(video.ID, thumbnailURL)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	if previousURL != nil {
+		cfg.releaseThumbnail(video.ID, *previousURL)
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
 }
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime"
@@ -12,16 +13,12 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	video2 "github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/video"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// upload limit of 1GB
-	const uploadLimit = 1 << 30
-	http.MaxBytesReader(w, r.Body, uploadLimit)
-
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -30,41 +27,48 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// authentication
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-	}
+	userID := caller.UserID
 
 	fmt.Println("uploading video", videoID, "by user", userID)
 
-	// check if user owns video
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+	// check if user can edit video in the active workspace
+	ws, ok := cfg.getActiveWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionEdit)
+	if !ok {
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You can't upload this video", err)
+	if !ws.contains(video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video in this workspace", nil)
+		return
+	}
+	// turn away uploads that clearly won't fit before reading them
+	if r.ContentLength > 0 && !cfg.checkStorageQuota(w, video.UserID, r.ContentLength-video.VideoSize, 0) {
 		return
 	}
 
-	// get video file
-	file, header, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't get video file", err)
+	// stream the video file from the form
+	checksums, ok := parseUploadChecksums(w, r)
+	if !ok {
 		return
 	}
-	defer func(file multipart.File) {
-		_ = file.Close()
-	}(file)
+	part, ok := openUploadPart(w, r, "video", cfg.uploadLimits.Video)
+	if !ok {
+		return
+	}
+	defer func(part *multipart.Part) {
+		_ = part.Close()
+	}(part)
 
 	// get a media type and check if it's a "video/mp4"
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't get video media type", err)
 		return
@@ -91,35 +95,128 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer func(name string) {
 		_ = os.Remove(name)
 	}(temp.Name())
-	defer func(file multipart.File) {
+	defer func(file *os.File) {
 		_ = file.Close()
-	}(file)
-	if _, err := io.Copy(temp, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't write temporary file", err)
+	}(temp)
+	uploaded, ok := copyUploadPart(w, temp, part, cfg.uploadLimits.Video)
+	if !ok {
+		return
+	}
+	if !checksums.verify(w, uploaded) {
+		return
+	}
+	if !cfg.scanUpload(w, r, video, "video", temp, uploaded.SHA256) {
+		return
+	}
+
+	// reuse the stored video if the same bytes were uploaded to this
+	// workspace before
+	namespace := storagePrefix(video.OrganizationID)
+	hash := hex.EncodeToString(uploaded.SHA256)
+	blob, err := cfg.db.AcquireBlob(namespace, hash, mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up stored video", err)
+		return
+	}
+	if blob.Key != "" {
+		if !cfg.checkStorageQuota(w, video.UserID, blob.Size-video.VideoSize, 0) {
+			cfg.releaseVideoObject(r.Context(), video.ID, cfg.videoURL(blob.Key))
+			return
+		}
+	} else {
+		blob, ok = cfg.uploadVideoObject(w, r, video, temp.Name(), database.Blob{
+			Namespace: namespace,
+			Hash:      hash,
+			MediaType: mediaType,
+		}, extensions[0])
+		if !ok {
+			return
+		}
+		uploadedKey := blob.Key
+		blob, err = cfg.recordNewBlob(blob, func() {
+			cfg.deleteVideoObject(r.Context(), video.ID, uploadedKey)
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record stored video", err)
+			return
+		}
+	}
+
+	// update video metadata
+	previousURL := video.VideoURL
+	videoURL := cfg.videoURL(blob.Key)
+	video.VideoURL = &videoURL
+	video.VideoSize = blob.Size
+	video.VideoSHA256 = nil
+	if blob.SHA256 != "" {
+		video.VideoSHA256 = &blob.SHA256
+	}
+	video.Status = database.VideoStatusActive
+	video.RejectionReason = nil
+	video.UpdatedAt = time.Now()
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		cfg.releaseVideoObject(r.Context(), video.ID, videoURL)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	if previousURL != nil {
+		cfg.releaseVideoObject(r.Context(), video.ID, *previousURL)
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
 
+// uploadVideoObject processes the uploaded video at path for fast start and
+// puts it into S3 under a new key derived from the blob's hash, filling in the
+// blob's key and size. It responds with an error and returns false if that
+// fails or the video won't fit in its owner's quota.
+func (cfg *apiConfig) uploadVideoObject(w http.ResponseWriter, r *http.Request, video database.Video, path string, blob database.Blob, extension string) (database.Blob, bool) {
 	// process video for fast-start
-	processed, err := video2.ProcessVideoForFastStart(temp.Name())
+	processed, err := video2.ProcessVideoForFastStart(path)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't process video for fast start", err)
-		return
+		return database.Blob{}, false
 	}
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(processed)
 	processedFile, err := os.Open(processed)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't open processed video file", err)
-		return
+		return database.Blob{}, false
 	}
-	defer func(file multipart.File) {
+	defer func(file *os.File) {
 		_ = file.Close()
 	}(processedFile)
 
+	processedInfo, err := processedFile.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processed video size", err)
+		return database.Blob{}, false
+	}
+	if !cfg.checkStorageQuota(w, video.UserID, processedInfo.Size()-video.VideoSize, 0) {
+		return database.Blob{}, false
+	}
+
+	// checksum the processed video so S3 can verify what it receives
+	hash := sha256.New()
+	if _, err := io.Copy(hash, processedFile); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't checksum processed video", err)
+		return database.Blob{}, false
+	}
+	if _, err := processedFile.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rewind processed video", err)
+		return database.Blob{}, false
+	}
+	checksum := hash.Sum(nil)
+	checksumBase64 := base64.StdEncoding.EncodeToString(checksum)
+
 	// check for video's aspect ratio
 	var prefix string
 	ratio, err := video2.GetVideoAspectRatio(processed)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get video aspect ratio", err)
-		return
+		return database.Blob{}, false
 	}
 	switch ratio {
 	default:
@@ -134,33 +231,26 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// put object into s3
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video name", err)
-		return
+	suffix, err := newBlobKeySuffix()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video key", err)
+		return database.Blob{}, false
 	}
-	videoKey := prefix + "/" + base64.RawURLEncoding.EncodeToString(randomBytes) + extensions[0]
+	blob.Key = blob.Namespace + prefix + "/" + blob.Hash + suffix + extension
+	blob.Size = processedInfo.Size()
+	blob.SHA256 = hex.EncodeToString(checksum)
 	if _, err := cfg.s3Client.PutObject(
 		r.Context(),
 		&s3.PutObjectInput{
-			Bucket:      &cfg.s3Bucket,
-			Key:         &videoKey,
-			Body:        processedFile,
-			ContentType: &mediaType,
+			Bucket:         &cfg.s3Bucket,
+			Key:            &blob.Key,
+			Body:           processedFile,
+			ContentType:    &blob.MediaType,
+			ChecksumSHA256: &checksumBase64,
 		},
 	); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't upload video to S3", err)
-		return
-	}
-
-	// update video metadata
-	videoURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, videoKey)
-	video.VideoURL = &videoURL
-	video.UpdatedAt = time.Now()
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return database.Blob{}, false
 	}
-
-	respondWithJSON(w, http.StatusOK, video)
+	return blob, true
 }
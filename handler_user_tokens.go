@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+	reauthCodeTTL        = 15 * time.Minute
+)
+
+// issueUserToken replaces the user's outstanding tokens for purpose with a
+// new one and returns it.
+func (cfg *apiConfig) issueUserToken(userID uuid.UUID, purpose database.UserTokenPurpose, ttl time.Duration) (string, error) {
+	return cfg.createUserToken(userID, purpose, "", ttl)
+}
+
+// issueEmailToken is issueUserToken for tokens emailed to the user that prove
+// they own their address. Redeeming one checks it's still their address.
+func (cfg *apiConfig) issueEmailToken(user database.User, purpose database.UserTokenPurpose, ttl time.Duration) (string, error) {
+	return cfg.createUserToken(user.ID, purpose, user.Email, ttl)
+}
+
+func (cfg *apiConfig) createUserToken(userID uuid.UUID, purpose database.UserTokenPurpose, email string, ttl time.Duration) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	if err := cfg.db.InvalidateUserTokens(userID, purpose); err != nil {
+		return "", err
+	}
+	err = cfg.db.CreateUserToken(userID, purpose, email, auth.HashToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendMail delivers msg in the background, so that slow mail servers don't
+// hold up requests and response times don't reveal which accounts exist.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Couldn't send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) sendEmailVerification(user database.User) error {
+	token, err := cfg.issueEmailToken(user, database.UserTokenVerifyEmail, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Welcome to Tubely!\n\nTo verify your email address, use this code within %d hours:\n\n%s\n",
+			int(emailVerificationTTL.Hours()), token,
+		),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	token, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Token), database.UserTokenVerifyEmail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem token", err)
+		return
+	}
+	if token.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid, expired or already used", nil)
+		return
+	}
+
+	// the token only vouches for the address it was sent to
+	verified, err := cfg.db.SetUserEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	if !verified {
+		respondWithError(w, http.StatusBadRequest, "Token was sent to an address that's no longer on the account", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerEmailVerifyResend(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	if err := cfg.sendEmailVerification(*user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordResetRequest emails a reset code to the account's address.
+// It responds the same whether or not the account exists, so it can't be used
+// to find out who has one.
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID != uuid.Nil && user.DisabledAt == nil {
+		token, err := cfg.issueEmailToken(user, database.UserTokenResetPassword, passwordResetTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token", err)
+			return
+		}
+		cfg.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Tubely password",
+			Body: fmt.Sprintf(
+				"Someone asked to reset your Tubely password. If it was you, use this code within %d minutes:\n\n%s\n\nIf it wasn't, you can ignore this email.\n",
+				int(passwordResetTTL.Minutes()), token,
+			),
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	token, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Token), database.UserTokenResetPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem token", err)
+		return
+	}
+	if token.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Token is invalid, expired or already used", nil)
+		return
+	}
+	// codes sent to a previous address don't prove anything anymore
+	user, err := cfg.db.GetUser(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || user.Email != token.Email {
+		respondWithError(w, http.StatusBadRequest, "Token was sent to an address that's no longer on the account", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	if err := cfg.db.SetUserPassword(token.UserID, hashedPassword); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set password", err)
+		return
+	}
+	// whoever knew the old password shouldn't stay logged in
+	if err := cfg.db.RevokeUserRefreshTokens(token.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	// receiving the code proves the user owns the address
+	if _, err := cfg.db.SetUserEmailVerified(token.UserID, token.Email); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestEmailTokensOnlyWorkForTheAddressTheyWereSentTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		purpose database.UserTokenPurpose
+		handler func(*apiConfig, http.ResponseWriter, *http.Request)
+		body    map[string]string
+	}{
+		{"verify email", database.UserTokenVerifyEmail, (*apiConfig).handlerEmailVerify, nil},
+		{"reset password", database.UserTokenResetPassword, (*apiConfig).handlerPasswordResetConfirm, map[string]string{"password": "new password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+			alice, err := cfg.db.GetUserByEmail("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			redeem := func(token string) int {
+				body := map[string]string{"token": token}
+				for k, v := range tt.body {
+					body[k] = v
+				}
+				handler := func(w http.ResponseWriter, r *http.Request) { tt.handler(cfg, w, r) }
+				return doJSON(t, http.HandlerFunc(handler), "POST", "/", "", body).Code
+			}
+
+			// a token sent to the old address doesn't vouch for the new one
+			stale, err := cfg.issueEmailToken(alice, tt.purpose, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.db.SetUserEmail(alice.ID, "alice@example.net"); err != nil {
+				t.Fatal(err)
+			}
+			if got := redeem(stale); got != http.StatusBadRequest {
+				t.Fatalf("stale token: got status %d, want %d", got, http.StatusBadRequest)
+			}
+			user, err := cfg.db.GetUser(alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.EmailVerifiedAt != nil {
+				t.Fatal("stale token verified the new address")
+			}
+
+			fresh, err := cfg.issueEmailToken(*user, tt.purpose, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redeem(fresh); got != http.StatusNoContent {
+				t.Fatalf("fresh token: got status %d, want %d", got, http.StatusNoContent)
+			}
+			user, err = cfg.db.GetUser(alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.EmailVerifiedAt == nil {
+				t.Error("fresh token didn't verify the address")
+			}
+		})
+	}
+}
@@ -2,12 +2,44 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/mail"
+	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
 )
 
+// publicUser is a user as shown to themselves. Responses use it rather than
+// database.User so that new columns, like secrets, aren't exposed by default.
+type publicUser struct {
+	ID              uuid.UUID         `json:"id"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	Email           string            `json:"email"`
+	Role            database.UserRole `json:"role"`
+	DisabledAt      *time.Time        `json:"disabled_at"`
+	EmailVerifiedAt *time.Time        `json:"email_verified_at"`
+}
+
+func newPublicUser(user database.User) publicUser {
+	return publicUser{
+		ID:              user.ID,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		Email:           user.Email,
+		Role:            user.Role,
+		DisabledAt:      user.DisabledAt,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+	}
+}
+
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
@@ -26,6 +58,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -34,7 +71,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	}
 
 	user, err := cfg.db.CreateUser(database.CreateUserParams{
-		Email:    params.Email,
+		Email:    email,
 		Password: hashedPassword,
 	})
 	if err != nil {
@@ -42,5 +79,351 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, user)
+	if err := cfg.sendEmailVerification(*user); err != nil {
+		// the user can ask for another one, so don't fail the signup
+		log.Printf("Couldn't send verification email to %s: %v", user.Email, err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, newPublicUser(*user))
+}
+
+// maxEmailLength is the longest address SMTP can deliver to.
+const maxEmailLength = 254
+
+// validateEmail checks that email is a bare address like "user@example.com"
+// and returns it without surrounding whitespace.
+func validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if len(email) > maxEmailLength {
+		return "", errors.New("email address is too long")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	if addr.Name != "" || addr.Address != email {
+		return "", errors.New("email must be a bare address")
+	}
+	return email, nil
+}
+
+// reauthenticate checks the user's password, and their second factor if they
+// have 2FA enabled, before a sensitive account change, so that a stolen
+// session isn't enough to take the account over. Users who only sign in
+// through SSO have no password, so they enter a code emailed to them by
+// handlerReauthCodeSend instead. Failures count towards login lockouts. It
+// responds with an error and returns false if the check fails.
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, r *http.Request, user database.User, password, code, recoveryCode, emailCode string) bool {
+	accountKey, ipKey := loginThrottleKeys(r, user.Email)
+	if !cfg.checkLoginLockout(w, accountKey, ipKey) {
+		return false
+	}
+	if user.Password == "" && emailCode == "" {
+		respondWithError(w, http.StatusUnauthorized, "Enter the confirmation code emailed to you; request one at /api/users/me/reauth_code", nil)
+		return false
+	}
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return false
+	}
+
+	var match bool
+	if user.Password != "" {
+		match, err = auth.CheckPasswordHash(password, user.Password)
+	} else {
+		// look the code up before using it, so that someone else's code isn't
+		// burnt by entering it here
+		var token database.UserToken
+		token, err = cfg.db.GetUserToken(auth.HashToken(emailCode), database.UserTokenReauth)
+		if err == nil && token.UserID == user.ID {
+			token, err = cfg.db.ConsumeUserToken(auth.HashToken(emailCode), database.UserTokenReauth)
+		}
+		match = token.UserID == user.ID
+	}
+	if err == nil && match && totp.Enabled() {
+		match, err = cfg.checkSecondFactor(totp, code, recoveryCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return false
+		}
+	}
+	if err != nil || !match {
+		if err := cfg.recordLoginFailure(accountKey, ipKey); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return false
+		}
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password or code", err)
+		return false
+	}
+	return true
+}
+
+// handlerReauthCodeSend emails a confirmation code to a user without a
+// password, for them to enter when making a sensitive account change.
+func (cfg *apiConfig) handlerReauthCodeSend(w http.ResponseWriter, r *http.Request) {
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	if user.Password != "" {
+		respondWithError(w, http.StatusConflict, "Confirm changes with your password instead", nil)
+		return
+	}
+
+	token, err := cfg.issueUserToken(user.ID, database.UserTokenReauth, reauthCodeTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create confirmation code", err)
+		return
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Tubely account change",
+		Body: fmt.Sprintf(
+			"To confirm a change to your Tubely account, use this code within %d minutes:\n\n%s\n\nIf you didn't ask for this, someone may have access to your account; sign out of all sessions.\n",
+			int(reauthCodeTTL.Minutes()), token,
+		),
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getCurrentUser looks up the caller's account, responding with an error and
+// returning false if it can't be found.
+func (cfg *apiConfig) getCurrentUser(w http.ResponseWriter, caller principal) (database.User, bool) {
+	user, err := cfg.db.GetUser(caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+func (cfg *apiConfig) handlerUserMeGet(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newPublicUser(user))
+}
+
+// handlerUserMeUpdate changes the caller's profile. Changing the email address
+// takes the password, or an emailed code for users without one, and marks the
+// address unverified until the user proves they own the new one.
+func (cfg *apiConfig) handlerUserMeUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email        *string `json:"email"`
+		Password     string  `json:"password"`
+		Code         string  `json:"code"`
+		RecoveryCode string  `json:"recovery_code"`
+		EmailCode    string  `json:"email_code"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	if params.Email == nil || *params.Email == user.Email {
+		respondWithJSON(w, http.StatusOK, newPublicUser(user))
+		return
+	}
+
+	email, err := validateEmail(*params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
+	// only say whether the address is taken to the account's owner, so that
+	// a session alone can't be used to probe for accounts
+	if !cfg.reauthenticate(w, r, user, params.Password, params.Code, params.RecoveryCode, params.EmailCode) {
+		return
+	}
+	existing, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if existing.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+		return
+	}
+
+	if err := cfg.db.SetUserEmail(user.ID, email); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+		return
+	}
+	// tell the old address, in case someone else made the change
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your Tubely email address was changed",
+		Body: fmt.Sprintf(
+			"The email address on your Tubely account was changed to %s.\n\nIf you didn't do this, reset your password and contact support.\n",
+			email,
+		),
+	})
+
+	updated, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	if err := cfg.sendEmailVerification(updated); err != nil {
+		log.Printf("Couldn't send verification email to %s: %v", updated.Email, err)
+	}
+	respondWithJSON(w, http.StatusOK, newPublicUser(updated))
+}
+
+// handlerUserPasswordChange sets a new password and logs out every other
+// session, since whoever knew the old password may be using one.
+func (cfg *apiConfig) handlerUserPasswordChange(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recovery_code"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required", nil)
+		return
+	}
+
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		// otherwise a stolen SSO session could add a password to log in with
+		respondWithError(w, http.StatusConflict, "Your account has no password; use password reset to set one", nil)
+		return
+	}
+	if !cfg.reauthenticate(w, r, user, params.CurrentPassword, params.Code, params.RecoveryCode, "") {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	if err := cfg.db.SetUserPassword(user.ID, hashedPassword); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set password", err)
+		return
+	}
+	if err := cfg.db.RevokeOtherSessions(user.ID, *caller.SessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUserMeDelete deletes the caller's account along with their videos,
+// sessions and stored media. Owners must hand over organizations that have
+// other members first.
+func (cfg *apiConfig) handlerUserMeDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		EmailCode    string `json:"email_code"`
+	}
+
+	caller, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+
+	orgs, err := cfg.db.GetUserOrganizations(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+	for _, org := range orgs {
+		if org.Role != database.OrgRoleOwner {
+			continue
+		}
+		owners, err := cfg.db.CountOrganizationOwners(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count owners", err)
+			return
+		}
+		members, err := cfg.db.GetOrganizationMembers(org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+			return
+		}
+		if owners == 1 && len(members) > 1 {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("You are the only owner of %q; make another member an owner first", org.Name), nil)
+			return
+		}
+	}
+
+	if !cfg.reauthenticate(w, r, user, params.Password, params.Code, params.RecoveryCode, params.EmailCode) {
+		return
+	}
+
+	videos, err := cfg.db.DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	for _, video := range videos {
+		cfg.deleteVideoAssets(r.Context(), video)
+	}
+	accountKey, _ := loginThrottleKeys(r, user.Email)
+	if err := cfg.db.ClearLoginFailures(accountKey); err != nil {
+		log.Printf("Couldn't clear failed logins for deleted user %s: %v", user.ID, err)
+	}
+	log.Printf("Deleted user %s and %d videos", user.ID, len(videos))
+
+	w.WriteHeader(http.StatusNoContent)
 }
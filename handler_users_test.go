@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestReauthenticateLeavesOtherUsersCodesAlone(t *testing.T) {
+	cfg := newTestConfig(t)
+	var users []database.User
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email})
+		if err != nil {
+			t.Fatalf("Couldn't create user: %v", err)
+		}
+		users = append(users, *user)
+	}
+	alice, bob := users[0], users[1]
+	bobCode, err := cfg.issueUserToken(bob.ID, database.UserTokenReauth, reauthCodeTTL)
+	if err != nil {
+		t.Fatalf("Couldn't issue code: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", "/api/users/me", nil)
+	if cfg.reauthenticate(rec, req, alice, "", "", "", bobCode) {
+		t.Fatal("alice reauthenticated with bob's code")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = httptest.NewRecorder()
+	if !cfg.reauthenticate(rec, req, bob, "", "", "", bobCode) {
+		t.Fatalf("bob couldn't reauthenticate with their code: %s", rec.Body)
+	}
+	token, err := cfg.db.GetUserToken(auth.HashToken(bobCode), database.UserTokenReauth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserID == bob.ID {
+		t.Error("bob's code can be used again")
+	}
+}
+
+func TestEmailChangeDoesNotRevealAccountsWithoutPassword(t *testing.T) {
+	cfg := newTestConfig(t)
+	token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	signUpAndLogIn(t, cfg, "bob@example.com", "battery staple")
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/users/me", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerUserMeUpdate))
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		status   int
+	}{
+		{"taken, wrong password", "bob@example.com", "guess", http.StatusUnauthorized},
+		{"free, wrong password", "carol@example.com", "guess", http.StatusUnauthorized},
+		{"taken, right password", "bob@example.com", "correct horse", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"email": tt.email, "password": tt.password}
+			if rec := doJSON(t, mux, "PATCH", "/api/users/me", token, body); rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
@@ -14,33 +19,51 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
 		return
 	}
+	userID := caller.UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
 
+	ws, ok := cfg.getActiveWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+	if !ws.Role.AtLeast(database.OrgRoleMember) {
+		respondWithError(w, http.StatusForbidden, "Viewers can't create videos in this workspace", nil)
+		return
+	}
+	params.OrganizationID = ws.OrganizationID
+
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPrivate
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	if !cfg.checkStorageQuota(w, userID, 0, 1) {
+		return
+	}
+
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	cfg.respondWithVideo(w, r, http.StatusCreated, video)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -51,34 +74,140 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	ws, ok := cfg.getActiveWorkspace(w, r, userID)
+	if !ok {
+		return
+	}
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionOwner)
+	if !ok {
+		return
+	}
+	if !ws.contains(video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video in this workspace", nil)
+		return
+	}
+
+	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	cfg.deleteVideoAssets(r.Context(), video)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Visibility  *database.Visibility `json:"visibility"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionEdit)
+	if !ok {
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	// optimistic concurrency: reject the edit if the client saw a stale version
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" && ifMatch != videoETag(video) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was modified since it was last fetched", nil)
+		return
+	}
+
+	if params.Title != nil {
+		title := strings.TrimSpace(*params.Title)
+		if title == "" {
+			respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+			return
+		}
+		if utf8.RuneCountInString(title) > maxVideoTitleLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Title can't be longer than %d characters", maxVideoTitleLength), nil)
+			return
+		}
+		video.Title = title
+	}
+	if params.Description != nil {
+		if utf8.RuneCountInString(*params.Description) > maxVideoDescriptionLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Description can't be longer than %d characters", maxVideoDescriptionLength), nil)
+			return
+		}
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !params.Visibility.Valid() {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+			return
+		}
+		permission, err := cfg.videoPermission(video, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+			return
+		}
+		if permission < permissionOwner {
+			respondWithError(w, http.StatusForbidden, "Only the owner can change a video's visibility", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+
+	unmodifiedSince := video.UpdatedAt
+	video.UpdatedAt = time.Now()
+	err = cfg.db.UpdateVideoIfUnmodified(video, unmodifiedSince)
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video was modified since it was last fetched", err)
+		return
+	}
+	if errors.Is(err, database.ErrVideoNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("ETag", videoETag(video))
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// videoETag derives a strong entity tag from the video's last modification time.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%x"`, video.UpdatedAt.UnixNano())
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
@@ -89,30 +218,88 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+	// anonymous callers can still see unlisted and public videos
+	var userID uuid.UUID
+	if caller, ok := principalFromContext(r.Context()); ok {
+		userID = caller.UserID
+	}
+
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionView)
+	if !ok {
 		return
 	}
-	respondWithJSON(w, http.StatusOK, video)
+	// links to videos work without a workspace, but a client browsing a
+	// workspace only sees that workspace's videos
+	if r.Header.Get(workspaceHeader) != "" {
+		ws, ok := cfg.getActiveWorkspace(w, r, userID)
+		if !ok {
+			return
+		}
+		if !ws.contains(video) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find video in this workspace", nil)
+			return
+		}
+	}
+	w.Header().Set("ETag", videoETag(video))
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	ws, ok := cfg.getActiveWorkspace(w, r, userID)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	var videos []database.Video
+	var err error
+	if ws.OrganizationID != nil {
+		videos, err = cfg.db.GetOrganizationVideos(*ws.OrganizationID)
+	} else {
+		videos, err = cfg.db.GetVideos(userID)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	cfg.respondWithVideos(w, r, videos)
+}
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+func (cfg *apiConfig) handlerVideosFeed(w http.ResponseWriter, r *http.Request) {
+	limit := defaultFeedLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxFeedLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxFeedLimit), err)
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if s := r.URL.Query().Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative integer", err)
+			return
+		}
+		offset = n
+	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	videos, err := cfg.db.GetPublicVideos(limit, offset)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, videos)
+	cfg.respondWithVideos(w, r, videos)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoVisibilityNeedsOwnerPermission(t *testing.T) {
+	cfg := newTestConfig(t)
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate))
+	tokens := map[string]string{}
+	users := map[string]database.User{}
+	for _, name := range []string{"alice", "bob"} {
+		email := name + "@example.com"
+		tokens[name] = signUpAndLogIn(t, cfg, email, "correct horse")
+		user, err := cfg.db.GetUserByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+
+	// bob uploads to alice's workspace, then leaves it but keeps an editor
+	// share on the video
+	org, err := cfg.db.CreateOrganization("Acme", users["alice"].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:          "launch",
+		Visibility:     database.VisibilityPrivate,
+		UserID:         users["bob"].ID,
+		OrganizationID: &org.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.ShareVideo(video.ID, users["bob"].ID, database.ShareRoleEditor); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caller     string
+		visibility database.Visibility
+		status     int
+	}{
+		{"workspace owner", "alice", database.VisibilityUnlisted, http.StatusOK},
+		{"uploader with an editor share", "bob", database.VisibilityPublic, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]any{"visibility": tt.visibility}
+			rec := doJSON(t, mux, "PATCH", "/api/videos/"+video.ID.String(), tokens[tt.caller], body)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Visibility != database.VisibilityUnlisted {
+		t.Errorf("video is %s, want %s", video.Visibility, database.VisibilityUnlisted)
+	}
+}
+
+func TestVideoMetaLimitsCountCharacters(t *testing.T) {
+	cfg := newTestConfig(t)
+	token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "launch",
+		Visibility: database.VisibilityPrivate,
+		UserID:     alice.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate))
+
+	tests := []struct {
+		name   string
+		body   map[string]string
+		status int
+	}{
+		{"longest title", map[string]string{"title": strings.Repeat("é", maxVideoTitleLength)}, http.StatusOK},
+		{"title too long", map[string]string{"title": strings.Repeat("é", maxVideoTitleLength+1)}, http.StatusBadRequest},
+		{"longest description", map[string]string{"description": strings.Repeat("日", maxVideoDescriptionLength)}, http.StatusOK},
+		{"description too long", map[string]string{"description": strings.Repeat("日", maxVideoDescriptionLength+1)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doJSON(t, mux, "PATCH", "/api/videos/"+video.ID.String(), token, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
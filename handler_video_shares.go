@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoSharesRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	if _, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionOwner); !ok {
+		return
+	}
+
+	shares, err := cfg.db.GetVideoShares(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shares", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+func (cfg *apiConfig) handlerVideoShare(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string             `json:"email"`
+		Role  database.ShareRole `json:"role"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Role must be viewer or editor", nil)
+		return
+	}
+
+	if _, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionOwner); !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't share a video with yourself", nil)
+		return
+	}
+
+	// the response is the same whether or not the address has an account, so
+	// that sharing can't be used to find out who's signed up
+	if user.ID == uuid.Nil {
+		cfg.sendMail(mailer.Message{
+			To:      params.Email,
+			Subject: "You've been invited to Tubely",
+			Body: fmt.Sprintf(
+				"%s would like to share a video with you on Tubely.\n\nSign up with this email address, then ask them to share it again.\n",
+				caller.Email,
+			),
+		})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := cfg.db.ShareVideo(videoID, user.ID, params.Role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't share video", err)
+		return
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "A video was shared with you on Tubely",
+		Body:    fmt.Sprintf("%s shared a video with you on Tubely. You'll find it under shared videos.\n", caller.Email),
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerVideoUnshare(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	shareUserID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	if _, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionOwner); !ok {
+		return
+	}
+
+	if err := cfg.db.UnshareVideo(videoID, shareUserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unshare video", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideosSharedRetrieve(w http.ResponseWriter, r *http.Request) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	videos, err := cfg.db.GetVideosSharedWith(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	cfg.respondWithVideos(w, r, videos)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoShareDoesNotRevealAccounts(t *testing.T) {
+	cfg := newTestConfig(t)
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/videos/{videoID}/shares", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.handlerVideoShare))
+	token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	signUpAndLogIn(t, cfg, "bob@example.com", "correct horse")
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := cfg.db.GetUserByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "launch",
+		Visibility: database.VisibilityPrivate,
+		UserID:     alice.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/api/videos/" + video.ID.String() + "/shares"
+	body := func(email string) map[string]string {
+		return map[string]string{"email": email, "role": string(database.ShareRoleViewer)}
+	}
+	registered := doJSON(t, mux, "PUT", target, token, body("bob@example.com"))
+	unknown := doJSON(t, mux, "PUT", target, token, body("nobody@example.com"))
+	if registered.Code != http.StatusAccepted {
+		t.Errorf("registered address: got status %d, want %d: %s", registered.Code, http.StatusAccepted, registered.Body)
+	}
+	if unknown.Code != registered.Code || unknown.Body.String() != registered.Body.String() {
+		t.Errorf("unknown address got %d %q, registered got %d %q", unknown.Code, unknown.Body, registered.Code, registered.Body)
+	}
+
+	role, err := cfg.db.GetVideoShareRole(video.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != database.ShareRoleViewer {
+		t.Errorf("got bob's role %q, want %q", role, database.ShareRoleViewer)
+	}
+}
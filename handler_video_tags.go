@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxTagLength    = 32
+	maxTagsPerVideo = 20
+	tagSearchLimit  = 10
+)
+
+// normalizeTag lowercases a tag and collapses its whitespace so that "Cats",
+// " cats " and "CATS" all refer to the same tag.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" {
+		return "", errors.New("tag can't be empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tag can't be longer than %d characters", maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+			return "", fmt.Errorf("tag %q contains invalid character %q", tag, r)
+		}
+	}
+	return tag, nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tags := make([]string, 0, len(params.Tags))
+	for _, tag := range params.Tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	video, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionEdit)
+	if !ok {
+		return
+	}
+
+	existing := make(map[string]bool, len(video.Tags))
+	for _, tag := range video.Tags {
+		existing[tag] = true
+	}
+	for _, tag := range tags {
+		existing[tag] = true
+	}
+	if len(existing) > maxTagsPerVideo {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A video can't have more than %d tags", maxTagsPerVideo), nil)
+		return
+	}
+
+	if err := cfg.db.AddVideoTags(videoID, tags); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add tags", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoTagsRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	if _, ok := cfg.getVideoWithPermission(w, videoID, userID, permissionEdit); !ok {
+		return
+	}
+
+	if err := cfg.db.RemoveVideoTag(videoID, tag); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTagsSearch(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToLower(strings.Join(strings.Fields(r.URL.Query().Get("q")), " "))
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+
+	tags, err := cfg.db.SearchTags(caller.UserID, prefix, tagSearchLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
+
+func (cfg *apiConfig) handlerVideosByTag(w http.ResponseWriter, r *http.Request) {
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	userID := caller.UserID
+
+	videos, err := cfg.db.GetVideosByTag(userID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	cfg.respondWithVideos(w, r, videos)
+}
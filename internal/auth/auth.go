@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,6 +21,24 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
+// Scopes limit what a credential may be used for.
+const (
+	// ScopeVideosRead allows listing and viewing videos.
+	ScopeVideosRead = "videos:read"
+	// ScopeVideosWrite allows creating videos and uploading media.
+	ScopeVideosWrite = "videos:write"
+	// ScopeAdmin allows using the admin API, if the user is an admin.
+	ScopeAdmin = "admin"
+)
+
+// ValidScope reports whether scope is one of the scopes above.
+func ValidScope(scope string) bool {
+	return scope == ScopeVideosRead || scope == ScopeVideosWrite || scope == ScopeAdmin
+}
+
+// APIKeyPrefix starts every API key so leaked keys are easy to recognize.
+const APIKeyPrefix = "tubely_"
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -38,50 +57,101 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// accessClaims are the claims in an access token.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	// SessionID is the login session the token was issued for.
+	SessionID string `json:"sid,omitempty"`
+	// Scope is the space-separated list of scopes the token grants.
+	Scope string `json:"scope"`
+	// Delegated marks tokens minted for an integration.
+	Delegated bool `json:"delegated,omitempty"`
+}
+
+// AccessToken is the content of an access token.
+type AccessToken struct {
+	UserID uuid.UUID
+	// SessionID is uuid.Nil for tokens issued before sessions existed.
+	SessionID uuid.UUID
+	// Audience is the service the token is meant for.
+	Audience string
+	Scopes   []string
+	// Delegated is set on tokens the user minted for an integration, which
+	// act on a session without being the session itself.
+	Delegated bool
+}
+
 func MakeJWT(
-	userID uuid.UUID,
-	tokenSecret string,
+	accessToken AccessToken,
+	keys *Keyring,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
-	return token.SignedString(signingKey)
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   accessToken.UserID.String(),
+			Audience:  jwt.ClaimStrings{accessToken.Audience},
+		},
+		Scope:     strings.Join(accessToken.Scopes, " "),
+		Delegated: accessToken.Delegated,
+	}
+	if accessToken.SessionID != uuid.Nil {
+		claims.SessionID = accessToken.SessionID.String()
+	}
+	return keys.sign(claims)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+// ValidateJWT checks an access token's signature, expiry, issuer and that it
+// was issued for audience.
+func ValidateJWT(tokenString string, keys *Keyring, audience string) (AccessToken, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
+		jwt.WithAudience(audience),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return AccessToken{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return AccessToken{}, fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+	accessToken := AccessToken{
+		UserID:    id,
+		Audience:  audience,
+		Scopes:    strings.Fields(claimsStruct.Scope),
+		Delegated: claimsStruct.Delegated,
+	}
+	for _, scope := range accessToken.Scopes {
+		if !ValidScope(scope) {
+			return AccessToken{}, fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	if claimsStruct.SessionID != "" {
+		accessToken.SessionID, err = uuid.Parse(claimsStruct.SessionID)
+		if err != nil {
+			return AccessToken{}, fmt.Errorf("invalid session ID: %w", err)
+		}
+	}
+	return accessToken, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -118,3 +188,26 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// MakeAPIKey generates a new random API key. Only its hash should be stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey hashes an API key for storage and lookup. API keys are long and
+// random, so unlike passwords they don't need a slow, salted hash.
+func HashAPIKey(key string) string {
+	return HashToken(key)
+}
+
+// HashToken hashes a random single-use token, such as one emailed to a user,
+// for storage and lookup.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HMACKeyID identifies the key derived from JWT_SECRET.
+const HMACKeyID = "hs256"
+
+// SigningKey is a key that access tokens are signed with, identified in
+// tokens by the kid header.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey and verifyKey are the same []byte for HMAC keys.
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey makes a symmetric HS256 key from a shared secret.
+func NewHMACKey(id, secret string) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// ParseSigningKey reads a PEM encoded RSA or Ed25519 private key, which is
+// used with RS256 or EdDSA respectively.
+func ParseSigningKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", privateKey)
+}
+
+// Keyring holds the key new tokens are signed with, plus retired keys that
+// tokens are still accepted from until their rotation window closes.
+type Keyring struct {
+	active  *SigningKey
+	retired map[string]retiredKey
+}
+
+type retiredKey struct {
+	key      *SigningKey
+	notAfter time.Time
+}
+
+func NewKeyring(active *SigningKey) *Keyring {
+	return &Keyring{
+		active:  active,
+		retired: map[string]retiredKey{},
+	}
+}
+
+// Retire accepts tokens signed with key until notAfter, without signing new
+// ones with it.
+func (k *Keyring) Retire(key *SigningKey, notAfter time.Time) {
+	k.retired[key.ID] = retiredKey{key: key, notAfter: notAfter}
+}
+
+// LoadKeyring builds the keyring from the environment's settings. Without a
+// keys directory, tokens are signed with HS256 and secret. Otherwise every
+// <kid>.pem file in dir is loaded, activeID signs new tokens, and the other
+// keys (and secret, if set) are accepted for rotationWindow from when they
+// were first found retired. That time is kept in a <kid>.retired file next to
+// the key, so that restarting doesn't extend the window.
+func LoadKeyring(dir, activeID, secret string, rotationWindow time.Duration) (*Keyring, error) {
+	if dir == "" {
+		if secret == "" {
+			return nil, errors.New("either a JWT secret or a keys directory is required")
+		}
+		return NewKeyring(NewHMACKey(HMACKeyID, secret)), nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseSigningKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load signing key %s: %w", path, err)
+		}
+		if key.ID == HMACKeyID {
+			return nil, fmt.Errorf("key ID %q is reserved for JWT_SECRET", HMACKeyID)
+		}
+		keys = append(keys, key)
+	}
+	if activeID == "" && len(keys) == 1 {
+		activeID = keys[0].ID
+	}
+
+	var keyring *Keyring
+	for _, key := range keys {
+		if key.ID == activeID {
+			keyring = NewKeyring(key)
+		}
+	}
+	if keyring == nil {
+		return nil, fmt.Errorf("active signing key %q not found in %s", activeID, dir)
+	}
+
+	// a key that's made active again gets a fresh window when next retired
+	if err := os.Remove(retiredPath(dir, activeID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	retire := func(key *SigningKey) error {
+		notAfter, err := retiredUntil(dir, key.ID, time.Now().Add(rotationWindow))
+		if err != nil {
+			return fmt.Errorf("couldn't get retirement time of signing key %s: %w", key.ID, err)
+		}
+		keyring.Retire(key, notAfter)
+		return nil
+	}
+	for _, key := range keys {
+		if key.ID != activeID {
+			if err := retire(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if secret != "" {
+		if err := retire(NewHMACKey(HMACKeyID, secret)); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+func retiredPath(dir, id string) string {
+	return filepath.Join(dir, id+".retired")
+}
+
+// retiredUntil returns when the retired key with id stops being accepted. The
+// first time the key is found retired, that's notAfter, which is recorded in
+// dir for later runs.
+func retiredUntil(dir, id string, notAfter time.Time) (time.Time, error) {
+	path := retiredPath(dir, id)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, err
+	}
+	notAfter = notAfter.UTC().Truncate(time.Second)
+	return notAfter, os.WriteFile(path, []byte(notAfter.Format(time.RFC3339)+"\n"), 0o600)
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signKey)
+}
+
+// keyFunc finds the key a token was signed with. Tokens from before kids were
+// added can only have come from the HMAC key.
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = HMACKeyID
+	}
+
+	key := k.active
+	if kid != key.ID {
+		retired, ok := k.retired[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if time.Now().After(retired.notAfter) {
+			return nil, fmt.Errorf("signing key %q has been retired", kid)
+		}
+		key = retired.key
+	}
+
+	// never let the token pick how its signature is checked
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are set for Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys other services can verify our tokens with.
+// HMAC keys are secret and never published.
+func (k *Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	keys := []*SigningKey{}
+	now := time.Now()
+	for _, retired := range k.retired {
+		if now.Before(retired.notAfter) {
+			keys = append(keys, retired.key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *SigningKey) int { return strings.Compare(a.ID, b.ID) })
+	keys = append([]*SigningKey{k.active}, keys...)
+
+	for _, key := range keys {
+		encode := base64.RawURLEncoding.EncodeToString
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         encode(publicKey.N.Bytes()),
+				E:         encode(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         encode(publicKey),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSigningKey writes a new Ed25519 key to dir as <id>.pem.
+func writeSigningKey(t *testing.T, dir, id string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeyringKeepsRetirementTimeAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "old")
+	writeSigningKey(t, dir, "new")
+
+	first, err := LoadKeyring(dir, "new", "secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a restart much later, with a longer window, mustn't extend it
+	second, err := LoadKeyring(dir, "new", "secret", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"old", HMACKeyID} {
+		want := first.retired[id].notAfter
+		if got := second.retired[id].notAfter; !got.Equal(want) {
+			t.Errorf("%s retires at %v after restarting, want %v", id, got, want)
+		}
+		if until := time.Until(want); until <= 0 || until > time.Hour {
+			t.Errorf("%s retires in %v, want within the hour", id, until)
+		}
+	}
+
+	// making the key active again clears its record, so it gets a fresh
+	// window when it's next retired
+	if _, err := LoadKeyring(dir, "old", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.retired")); !os.IsNotExist(err) {
+		t.Errorf("got %v checking old.retired, want it removed", err)
+	}
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, kept so users can tell their keys apart.
+	Prefix  string   `json:"prefix"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		last_used_at,
+		revoked_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes
+`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+	)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (id, created_at, user_id, name, prefix, key_hash, scopes)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash, strings.Join(params.Scopes, " "))
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return scanAPIKey(c.db.QueryRow(`SELECT`+apiKeyColumns+`FROM api_keys WHERE id = ?`, id))
+}
+
+// GetAPIKeys returns the user's API keys, including revoked ones.
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByHash looks up a key by the hash of its secret. It returns a zero
+// APIKey if there is no such key.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys. It reports whether the user
+// had an active key with that ID.
+func (c Client) RevokeAPIKey(userID, id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditLogEntry records a security-relevant action, such as an admin
+// disabling an account.
+type AuditLogEntry struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditLogEntryParams
+}
+
+type CreateAuditLogEntryParams struct {
+	// ActorID is the user who performed the action, or nil for actions the
+	// system took on its own.
+	ActorID *uuid.UUID `json:"actor_id"`
+	Action  string     `json:"action"`
+	Target  string     `json:"target"`
+	Detail  string     `json:"detail"`
+}
+
+func (c Client) CreateAuditLogEntry(params CreateAuditLogEntryParams) error {
+	query := `
+	INSERT INTO audit_log (id, created_at, actor_id, action, target, detail)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.ActorID, params.Action, params.Target, params.Detail)
+	return err
+}
+
+// GetAuditLog returns the most recent audit log entries, newest first.
+func (c Client) GetAuditLog(limit int) ([]AuditLogEntry, error) {
+	query := `
+	SELECT id, created_at, actor_id, action, target, detail
+	FROM audit_log
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditLogEntry{}
+	for rows.Next() {
+		var entry AuditLogEntry
+		if err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.Action, &entry.Target, &entry.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
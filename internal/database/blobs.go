@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Blob is stored media that videos share when the same bytes are uploaded
+// more than once. Its key is derived from its hash plus a random suffix, so
+// that media stored again after a blob was deleted never shares its key, and
+// it's deleted once nothing references it.
+type Blob struct {
+	// Key is where the media is stored, in S3 or the assets directory.
+	Key string
+	// Namespace is the storage prefix of the workspace the blob belongs to.
+	// Blobs are only shared within a workspace.
+	Namespace string
+	// Hash is the hex SHA-256 of the bytes uploaded, before any processing.
+	Hash      string
+	MediaType string
+	// Size and SHA256 describe the stored media, which can differ from the
+	// upload. SHA256 is hex, and empty for blobs stored before it was kept.
+	Size      int64
+	SHA256    string
+	RefCount  int
+	CreatedAt time.Time
+}
+
+// AcquireBlob adds a reference to the blob uploaded with the given hash and
+// media type in namespace and returns it, or returns a zero Blob if there
+// isn't one. Blobs whose last reference is being dropped aren't revived, as
+// their media may already be on its way out.
+func (c Client) AcquireBlob(namespace, hash, mediaType string) (Blob, error) {
+	query := `
+		UPDATE blobs
+		SET ref_count = ref_count + 1
+		WHERE namespace = ? AND hash = ? AND media_type = ? AND ref_count > 0
+		RETURNING key, namespace, hash, media_type, size, sha256, ref_count, created_at
+	`
+	var b Blob
+	err := c.db.QueryRow(query, namespace, hash, mediaType).Scan(&b.Key, &b.Namespace, &b.Hash, &b.MediaType, &b.Size, &b.SHA256, &b.RefCount, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Blob{}, nil
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	return b, nil
+}
+
+// CreateBlob records newly stored media with a single reference. It returns
+// false, without creating anything, if a blob with the same hash and media
+// type already exists in the namespace.
+func (c Client) CreateBlob(b Blob) (bool, error) {
+	query := `
+		INSERT INTO blobs (key, namespace, hash, media_type, size, sha256, ref_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+	`
+	result, err := c.db.Exec(query, b.Key, b.Namespace, b.Hash, b.MediaType, b.Size, b.SHA256)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReleaseBlob drops a reference to the blob stored at key, deleting the blob
+// when it was the last one. It returns true if nothing references the media
+// anymore, including media stored before blobs were tracked, so that the
+// caller can delete it.
+func (c Client) ReleaseBlob(key string) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var refCount int
+	err = tx.QueryRow(`
+		UPDATE blobs
+		SET ref_count = ref_count - 1
+		WHERE key = ?
+		RETURNING ref_count
+	`, key).Scan(&refCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if refCount > 0 {
+		return false, tx.Commit()
+	}
+	if _, err := tx.Exec(`DELETE FROM blobs WHERE key = ?`, key); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobReferences(t *testing.T) {
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := Blob{Key: "ns/abc-1.png", Namespace: "ns/", Hash: "abc", MediaType: "image/png", Size: 3}
+
+	if got, err := c.AcquireBlob(blob.Namespace, blob.Hash, blob.MediaType); err != nil || got.Key != "" {
+		t.Fatalf("AcquireBlob() before creating = %+v, %v; want nothing", got, err)
+	}
+	if created, err := c.CreateBlob(blob); err != nil || !created {
+		t.Fatalf("CreateBlob() = %v, %v; want true", created, err)
+	}
+
+	// a concurrent upload of the same bytes stored its own copy
+	second := blob
+	second.Key = "ns/abc-2.png"
+	if created, err := c.CreateBlob(second); err != nil || created {
+		t.Fatalf("CreateBlob() of a second copy = %v, %v; want false", created, err)
+	}
+	got, err := c.AcquireBlob(blob.Namespace, blob.Hash, blob.MediaType)
+	if err != nil || got.Key != blob.Key || got.RefCount != 2 {
+		t.Fatalf("AcquireBlob() = %+v, %v; want %s with 2 references", got, err, blob.Key)
+	}
+
+	for _, want := range []bool{false, true} {
+		unreferenced, err := c.ReleaseBlob(blob.Key)
+		if err != nil || unreferenced != want {
+			t.Fatalf("ReleaseBlob() = %v, %v; want %v", unreferenced, err, want)
+		}
+	}
+	// once released, the media may be deleted at any moment, so it can't be
+	// shared again
+	if got, err := c.AcquireBlob(blob.Namespace, blob.Hash, blob.MediaType); err != nil || got.Key != "" {
+		t.Fatalf("AcquireBlob() after releasing = %+v, %v; want nothing", got, err)
+	}
+}
@@ -32,21 +32,70 @@ func (c *Client) autoMigrate() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
+		email TEXT UNIQUE NOT NULL,
+		role TEXT NOT NULL DEFAULT 'user',
+		disabled_at TIMESTAMP,
+		email_verified_at TIMESTAMP,
+		quota_bytes INTEGER,
+		quota_videos INTEGER
 	);
 	`
 	_, err := c.db.Exec(userTable)
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfNotExists("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "disabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "quota_bytes", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "quota_videos", "INTEGER")
+	if err != nil {
+		return err
+	}
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("user_tokens", "email", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
 		token TEXT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		revoked_at TIMESTAMP,
+		used_at TIMESTAMP,
 		user_id TEXT NOT NULL,
+		family_id TEXT,
 		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
@@ -54,6 +103,30 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfNotExists("refresh_tokens", "family_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "used_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "last_used_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "ip_address", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.backfillRefreshTokenFamilies()
+	if err != nil {
+		return err
+	}
 
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
@@ -64,26 +137,381 @@ func (c *Client) autoMigrate() error {
 		description TEXT,
 		thumbnail_url TEXT,
 		video_url TEXT TEXT,
+		video_size INTEGER NOT NULL DEFAULT 0,
+		thumbnail_size INTEGER NOT NULL DEFAULT 0,
+		video_sha256 TEXT,
+		status TEXT NOT NULL DEFAULT 'active',
+		rejection_reason TEXT,
+		visibility TEXT NOT NULL DEFAULT 'unlisted',
 		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
+		organization_id TEXT,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
 	);
 	`
 	_, err = c.db.Exec(videoTable)
 	if err != nil {
 		return err
 	}
+	// videos created before visibility existed were reachable by anyone with
+	// the link, so they default to unlisted
+	err = c.addColumnIfNotExists("videos", "visibility", "TEXT NOT NULL DEFAULT 'unlisted'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "organization_id", "TEXT REFERENCES organizations(id)")
+	if err != nil {
+		return err
+	}
+	// media uploaded before sizes were tracked counts as zero bytes
+	err = c.addColumnIfNotExists("videos", "video_size", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "thumbnail_size", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "video_sha256", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "status", "TEXT NOT NULL DEFAULT 'active'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "rejection_reason", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	auditLogTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor_id TEXT,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(actor_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(auditLogTable)
+	if err != nil {
+		return err
+	}
+
+	loginFailureTable := `
+	CREATE TABLE IF NOT EXISTS login_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failed_at TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(loginFailureTable)
+	if err != nil {
+		return err
+	}
+
+	userTOTPTable := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		enabled_at TIMESTAMP,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTOTPTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code_hash TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		PRIMARY KEY(user_id, code_hash),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	oidcStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_states (
+		state TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcStateTable)
+	if err != nil {
+		return err
+	}
+
+	blobTable := `
+	CREATE TABLE IF NOT EXISTS blobs (
+		key TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL DEFAULT '',
+		ref_count INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(namespace, hash, media_type)
+	);
+	`
+	_, err = c.db.Exec(blobTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("blobs", "sha256", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	organizationMemberTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(organization_id, user_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationMemberTable)
+	if err != nil {
+		return err
+	}
+
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT UNIQUE NOT NULL
+	);
+	`
+	_, err = c.db.Exec(tagTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	`
+	_, err = c.db.Exec(videoTagTable)
+	if err != nil {
+		return err
+	}
+
+	videoShareTable := `
+	CREATE TABLE IF NOT EXISTS video_shares (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoShareTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	playlistVideoTable := `
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistVideoTable)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfNotExists adds a column to a table created by an earlier version
+// of autoMigrate, since CREATE TABLE IF NOT EXISTS leaves existing tables as is.
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM audit_log"); err != nil {
+		return fmt.Errorf("failed to reset table audit_log: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM login_failures"); err != nil {
+		return fmt.Errorf("failed to reset table login_failures: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_totp"); err != nil {
+		return fmt.Errorf("failed to reset table user_totp: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_states: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_shares"); err != nil {
+		return fmt.Errorf("failed to reset table video_shares: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM blobs"); err != nil {
+		return fmt.Errorf("failed to reset table blobs: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
 	return nil
 }
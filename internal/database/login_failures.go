@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailure counts recent failed logins for an account or client address.
+type LoginFailure struct {
+	// Key is what the failures are counted against, such as "account:<email>"
+	// or "ip:<address>".
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// GetLoginFailure returns the failures counted against key, or a zero
+// LoginFailure if there are none.
+func (c Client) GetLoginFailure(key string) (LoginFailure, error) {
+	query := `
+		SELECT key, failures, last_failed_at, locked_until
+		FROM login_failures
+		WHERE key = ?
+	`
+	var f LoginFailure
+	err := c.db.QueryRow(query, key).Scan(&f.Key, &f.Failures, &f.LastFailedAt, &f.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginFailure{}, nil
+	}
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	return f, nil
+}
+
+// RecordLoginFailure counts another failed login against key at now and
+// returns the updated count. Failures are counted afresh, and any lockout
+// lifted, if none happened since staleBefore and no lockout lasted past it.
+// The count is updated in one statement, so concurrent failures all count.
+func (c Client) RecordLoginFailure(key string, now, staleBefore time.Time) (LoginFailure, error) {
+	query := `
+		INSERT INTO login_failures (key, failures, last_failed_at, locked_until)
+		VALUES (?, 1, ?, NULL)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE
+				WHEN max(last_failed_at, coalesce(locked_until, last_failed_at)) < ? THEN 1
+				ELSE failures + 1
+			END,
+			locked_until = CASE
+				WHEN max(last_failed_at, coalesce(locked_until, last_failed_at)) < ? THEN NULL
+				ELSE locked_until
+			END,
+			last_failed_at = excluded.last_failed_at
+		RETURNING key, failures, last_failed_at, locked_until
+	`
+	var f LoginFailure
+	err := c.db.QueryRow(query, key, now, staleBefore, staleBefore).Scan(&f.Key, &f.Failures, &f.LastFailedAt, &f.LockedUntil)
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	return f, nil
+}
+
+// LockLoginFailures locks key out until lockedUntil, unless it's already
+// locked out for longer.
+func (c Client) LockLoginFailures(key string, lockedUntil time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = ?
+		WHERE key = ? AND (locked_until IS NULL OR locked_until < ?)
+	`
+	_, err := c.db.Exec(query, lockedUntil, key, lockedUntil)
+	return err
+}
+
+func (c Client) ClearLoginFailures(key string) error {
+	_, err := c.db.Exec(`DELETE FROM login_failures WHERE key = ?`, key)
+	return err
+}
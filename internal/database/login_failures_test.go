@@ -0,0 +1,58 @@
+package database
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordLoginFailure(t *testing.T) {
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	staleBefore := now.Add(-time.Hour)
+
+	// every concurrent failure is counted
+	const attempts = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.RecordLoginFailure("ip:192.0.2.1", now, staleBefore); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("RecordLoginFailure() = %v", err)
+	}
+	f, err := c.GetLoginFailure("ip:192.0.2.1")
+	if err != nil || f.Failures != attempts {
+		t.Fatalf("GetLoginFailure() = %+v, %v; want %d failures", f, err, attempts)
+	}
+
+	// a lockout keeps failures counting until it's over
+	lockedUntil := now.Add(2 * time.Hour)
+	if err := c.LockLoginFailures("ip:192.0.2.1", lockedUntil); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(90 * time.Minute)
+	f, err = c.RecordLoginFailure("ip:192.0.2.1", later, later.Add(-time.Hour))
+	if err != nil || f.Failures != attempts+1 || f.LockedUntil == nil || !f.LockedUntil.Equal(lockedUntil) {
+		t.Fatalf("RecordLoginFailure() while locked out = %+v, %v; want %d failures until %v", f, err, attempts+1, lockedUntil)
+	}
+
+	// failures older than the window are forgotten
+	later = lockedUntil.Add(2 * time.Hour)
+	f, err = c.RecordLoginFailure("ip:192.0.2.1", later, later.Add(-time.Hour))
+	if err != nil || f.Failures != 1 || f.LockedUntil != nil {
+		t.Fatalf("RecordLoginFailure() after the window = %+v, %v; want 1 failure", f, err)
+	}
+}
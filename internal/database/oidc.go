@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCState remembers an OIDC login in progress between redirecting the user
+// to the identity provider and the provider redirecting them back.
+type OIDCState struct {
+	State        string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Nonce        string
+	CodeVerifier string
+}
+
+func (c Client) CreateOIDCState(state OIDCState) error {
+	// logins that were abandoned are cleaned up as new ones start
+	if _, err := c.db.Exec(`DELETE FROM oidc_states WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oidc_states (state, created_at, expires_at, nonce, code_verifier)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, state.State, state.ExpiresAt, state.Nonce, state.CodeVerifier)
+	return err
+}
+
+// ConsumeOIDCState returns and deletes a login state, so each can only be
+// used once. A zero OIDCState is returned if there is no such state.
+func (c Client) ConsumeOIDCState(state string) (OIDCState, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return OIDCState{}, err
+	}
+	defer tx.Rollback()
+
+	var s OIDCState
+	err = tx.QueryRow(`
+		SELECT state, created_at, expires_at, nonce, code_verifier
+		FROM oidc_states
+		WHERE state = ?
+	`, state).Scan(&s.State, &s.CreatedAt, &s.ExpiresAt, &s.Nonce, &s.CodeVerifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OIDCState{}, nil
+	}
+	if err != nil {
+		return OIDCState{}, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM oidc_states WHERE state = ?`, state); err != nil {
+		return OIDCState{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return OIDCState{}, err
+	}
+	return s, nil
+}
+
+// UserIdentity links a user to their account at an identity provider.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetUserByIdentity returns the user linked to the provider account, or nil
+// if there isn't one.
+func (c Client) GetUserByIdentity(issuer, subject string) (*User, error) {
+	query := `
+		SELECT` + userColumns + `
+		FROM users
+		JOIN user_identities ui ON users.id = ui.user_id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`
+	user, err := scanUser(c.db.QueryRow(query, issuer, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c Client) CreateUserIdentity(identity UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, identity.Issuer, identity.Subject, identity.UserID.String(), identity.Email)
+	return err
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrgRole is a user's role within an organization.
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"
+	OrgRoleAdmin  OrgRole = "admin"
+	OrgRoleMember OrgRole = "member"
+	OrgRoleViewer OrgRole = "viewer"
+)
+
+var orgRoleRanks = map[OrgRole]int{
+	OrgRoleViewer: 1,
+	OrgRoleMember: 2,
+	OrgRoleAdmin:  3,
+	OrgRoleOwner:  4,
+}
+
+func (r OrgRole) Valid() bool {
+	_, ok := orgRoleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r grants everything other does. The empty role,
+// used for non-members, is below every other role.
+func (r OrgRole) AtLeast(other OrgRole) bool {
+	return orgRoleRanks[r] >= orgRoleRanks[other]
+}
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+}
+
+// UserOrganization is an organization as seen by one of its members.
+type UserOrganization struct {
+	Organization
+	Role OrgRole `json:"role"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Email          string    `json:"email"`
+	Role           OrgRole   `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CreateOrganization creates an organization with ownerID as its first owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO organizations (id, created_at, updated_at, name)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, ownerID, OrgRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+	return c.GetOrganization(id)
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+	SELECT id, created_at, updated_at, name
+	FROM organizations
+	WHERE id = ?
+	`
+	var org Organization
+	err := c.db.QueryRow(query, id).Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, nil
+		}
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+func (c Client) GetUserOrganizations(userID uuid.UUID) ([]UserOrganization, error) {
+	query := `
+	SELECT o.id, o.created_at, o.updated_at, o.name, m.role
+	FROM organizations o
+	JOIN organization_members m ON m.organization_id = o.id
+	WHERE m.user_id = ?
+	ORDER BY o.name
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []UserOrganization{}
+	for rows.Next() {
+		var org UserOrganization
+		if err := rows.Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name, &org.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetOrganizationRole returns the user's role in the organization, or an empty
+// role if they aren't a member.
+func (c Client) GetOrganizationRole(orgID, userID uuid.UUID) (OrgRole, error) {
+	query := `
+	SELECT role
+	FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	var role OrgRole
+	err := c.db.QueryRow(query, orgID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+	SELECT m.organization_id, m.user_id, u.email, m.role, m.created_at
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.organization_id = ?
+	ORDER BY m.created_at
+	`
+
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetOrganizationMember adds the user to the organization with the given role,
+// or changes their role if they are already a member.
+func (c Client) SetOrganizationMember(orgID, userID uuid.UUID, role OrgRole) error {
+	query := `
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(organization_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, orgID, userID, role)
+	return err
+}
+
+func (c Client) RemoveOrganizationMember(orgID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID, userID)
+	return err
+}
+
+func (c Client) CountOrganizationOwners(orgID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM organization_members
+	WHERE organization_id = ? AND role = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, orgID, OrgRoleOwner).Scan(&count)
+	return count, err
+}
+
+func (c Client) GetOrganizationVideos(orgID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE organization_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, orgID)
+}
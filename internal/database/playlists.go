@@ -0,0 +1,208 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Playlist struct {
+	ID        uuid.UUID   `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	VideoIDs  []uuid.UUID `json:"video_ids"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UserID      uuid.UUID `json:"user_id"`
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, description, user_id
+	FROM playlists
+	WHERE id = ?
+	`
+
+	var playlist Playlist
+	err := c.db.QueryRow(query, id).Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.UserID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+
+	playlist.VideoIDs, err = c.GetPlaylistVideoIDs(playlist.ID)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, description, user_id
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		var playlist Playlist
+		if err := rows.Scan(
+			&playlist.ID,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Title,
+			&playlist.Description,
+			&playlist.UserID,
+		); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range playlists {
+		playlists[i].VideoIDs, err = c.GetPlaylistVideoIDs(playlists[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return playlists, nil
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = ?,
+		title = ?,
+		description = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, playlist.UpdatedAt, playlist.Title, playlist.Description, playlist.ID)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	if _, err := c.db.Exec(`DELETE FROM playlist_videos WHERE playlist_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(`DELETE FROM playlists WHERE id = ?`, id)
+	return err
+}
+
+// GetPlaylistVideoIDs returns the IDs of the playlist's videos in play order.
+func (c Client) GetPlaylistVideoIDs(playlistID uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+	SELECT video_id
+	FROM playlist_videos
+	WHERE playlist_id = ?
+	ORDER BY position
+	`
+
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videoIDs := []uuid.UUID{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		if err := rows.Scan(&videoID); err != nil {
+			return nil, err
+		}
+		videoIDs = append(videoIDs, videoID)
+	}
+	return videoIDs, rows.Err()
+}
+
+// AddPlaylistVideo appends a video to the end of the playlist. Adding a video
+// that is already in the playlist is a no-op.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID) error {
+	query := `
+	INSERT OR IGNORE INTO playlist_videos (playlist_id, video_id, position, created_at)
+	SELECT ?, ?, COALESCE(MAX(position), -1) + 1, CURRENT_TIMESTAMP
+	FROM playlist_videos
+	WHERE playlist_id = ?
+	`
+	_, err := c.db.Exec(query, playlistID, videoID, playlistID)
+	return err
+}
+
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) error {
+	query := `
+	DELETE FROM playlist_videos
+	WHERE playlist_id = ? AND video_id = ?
+	`
+	_, err := c.db.Exec(query, playlistID, videoID)
+	return err
+}
+
+// ReorderPlaylist sets the play order of the playlist's videos to the order of
+// videoIDs, which must contain exactly the videos already in the playlist.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, videoID := range videoIDs {
+		_, err := tx.Exec(`
+			UPDATE playlist_videos
+			SET position = ?
+			WHERE playlist_id = ? AND video_id = ?
+		`, position, playlistID, videoID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -2,62 +2,194 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrRefreshTokenInvalid is returned for refresh tokens that don't exist,
+	// have expired or have been revoked.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. Its whole family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// UsedAt is set once the token has been exchanged for its successor.
+	UsedAt     *time.Time `json:"used_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
 }
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
+	// Token is a bearer credential; it's only sent to the client it was
+	// issued to, never listed.
+	Token  string    `json:"-"`
+	UserID uuid.UUID `json:"user_id"`
+	// FamilyID links every token rotated from the same login, so that a
+	// replayed token can take down the whole chain.
+	FamilyID  uuid.UUID `json:"family_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// UserAgent and IPAddress describe the client the token was issued to.
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+}
+
+// Active reports whether the token can still be exchanged.
+func (rt RefreshToken) Active(now time.Time) bool {
+	return rt.RevokedAt == nil && rt.UsedAt == nil && now.Before(rt.ExpiresAt)
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	if params.FamilyID == uuid.Nil {
+		params.FamilyID = uuid.New()
+	}
+	if err := createRefreshToken(c.db, params); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(params.Token)
+}
+
+func createRefreshToken(db execer, params CreateRefreshTokenParams) error {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
 			created_at,
 			updated_at,
+			last_used_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			family_id,
+			expires_at,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := db.Exec(query,
+		params.Token,
+		params.UserID.String(),
+		params.FamilyID.String(),
+		params.ExpiresAt,
+		params.UserAgent,
+		params.IPAddress,
+	)
+	return err
+}
+
+// RotateRefreshToken marks token as used and issues next in its place, in the
+// same family and for the same user. If token was already used, the family is
+// revoked and ErrRefreshTokenReused is returned.
+func (c Client) RotateRefreshToken(token string, next CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	defer tx.Rollback()
 
-	return c.GetRefreshToken(params.Token)
+	rt, err := getRefreshToken(tx, token)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if rt.Token == "" || rt.RevokedAt != nil || !time.Now().Before(rt.ExpiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	// the conditional update keeps two concurrent refreshes from both winning
+	var rotated int64
+	if rt.UsedAt == nil {
+		result, err := tx.Exec(`
+			UPDATE refresh_tokens
+			SET used_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE token = ? AND used_at IS NULL
+		`, token)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		rotated, err = result.RowsAffected()
+		if err != nil {
+			return RefreshToken{}, err
+		}
+	}
+	if rotated == 0 {
+		if err := revokeRefreshTokenFamily(tx, rt.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	next.UserID = rt.UserID
+	next.FamilyID = rt.FamilyID
+	if err := createRefreshToken(tx, next); err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(next.Token)
 }
 
+// RevokeRefreshToken revokes token along with every other token in its family,
+// ending the login session it belongs to.
 func (c Client) RevokeRefreshToken(token string) error {
+	rt, err := c.GetRefreshToken(token)
+	if err != nil {
+		return err
+	}
+	if rt.Token == "" {
+		return nil
+	}
+	return revokeRefreshTokenFamily(c.db, rt.FamilyID)
+}
+
+func revokeRefreshTokenFamily(db execer, familyID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := db.Exec(query, familyID.String())
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every active refresh token the user holds,
+// logging them out everywhere.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		WHERE user_id = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.Exec(query, userID.String())
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
+	return getRefreshToken(c.db, token)
+}
+
+func getRefreshToken(db rowQuerier, token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, family_id, expires_at, revoked_at, used_at,
+			last_used_at, user_agent, ip_address
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
-	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	var userID, familyID string
+	err := db.QueryRow(query, token).Scan(
+		&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &familyID, &rt.ExpiresAt, &rt.RevokedAt, &rt.UsedAt,
+		&rt.LastUsedAt, &rt.UserAgent, &rt.IPAddress,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -69,6 +201,10 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	rt.FamilyID, err = uuid.Parse(familyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
 
 	return rt, nil
 }
@@ -81,3 +217,33 @@ func (c Client) DeleteRefreshToken(token string) error {
 	_, err := c.db.Exec(query, token)
 	return err
 }
+
+// backfillRefreshTokenFamilies puts each token issued before families existed
+// in a family of its own.
+func (c *Client) backfillRefreshTokenFamilies() error {
+	rows, err := c.db.Query(`SELECT token FROM refresh_tokens WHERE family_id IS NULL`)
+	if err != nil {
+		return err
+	}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		_, err := c.db.Exec(`UPDATE refresh_tokens SET family_id = ? WHERE token = ?`, uuid.New().String(), token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login, tracked as the family of refresh tokens rotated from it.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+}
+
+// GetSessions returns the user's active sessions, most recently used first.
+func (c Client) GetSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT family_id, last_used_at, expires_at, user_agent, ip_address
+		FROM refresh_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND used_at IS NULL
+		ORDER BY last_used_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var familyID string
+		if err := rows.Scan(&familyID, &session.LastUsedAt, &session.ExpiresAt, &session.UserAgent, &session.IPAddress); err != nil {
+			return nil, err
+		}
+		if !now.Before(session.ExpiresAt) {
+			continue
+		}
+		session.ID, err = uuid.Parse(familyID)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// a session started when the first token in its family was issued
+	for i := range sessions {
+		err := c.db.QueryRow(`
+			SELECT created_at FROM refresh_tokens
+			WHERE family_id = ?
+			ORDER BY created_at
+			LIMIT 1
+		`, sessions[i].ID.String()).Scan(&sessions[i].CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+// SessionActive reports whether the session still has a usable refresh token,
+// i.e. it hasn't been revoked.
+func (c Client) SessionActive(sessionID uuid.UUID) (bool, error) {
+	query := `
+		SELECT 1 FROM refresh_tokens
+		WHERE family_id = ? AND revoked_at IS NULL AND used_at IS NULL
+		LIMIT 1
+	`
+	var found int
+	err := c.db.QueryRow(query, sessionID.String()).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeSession ends one of the user's sessions, reporting whether there was
+// an active session with that ID to revoke.
+func (c Client) RevokeSession(userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), sessionID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeOtherSessions ends every session the user has except keep.
+func (c Client) RevokeOtherSessions(userID, keep uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id != ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), keep.String())
+	return err
+}
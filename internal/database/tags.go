@@ -0,0 +1,119 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AddVideoTags attaches the named tags to a video, creating any tags that
+// don't exist yet. Tags already on the video are left untouched.
+func (c Client) AddVideoTags(videoID uuid.UUID, names []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO tags (id, created_at, name)
+			VALUES (?, CURRENT_TIMESTAMP, ?)
+		`, uuid.New(), name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO video_tags (video_id, tag_id, created_at)
+			SELECT ?, id, CURRENT_TIMESTAMP FROM tags WHERE name = ?
+		`, videoID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (c Client) RemoveVideoTag(videoID uuid.UUID, name string) error {
+	query := `
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag_id IN (SELECT id FROM tags WHERE name = ?)
+	`
+	_, err := c.db.Exec(query, videoID, name)
+	return err
+}
+
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT t.name
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	WHERE vt.video_id = ?
+	ORDER BY t.name
+	`
+	return c.queryTagNames(query, videoID)
+}
+
+// visibleVideosFilter matches the videos a user may find: their personal
+// videos, videos in workspaces they belong to, videos shared with them and
+// public videos. It takes the user's ID three times and then VisibilityPublic.
+const visibleVideosFilter = `(
+		(videos.user_id = ? AND videos.organization_id IS NULL)
+		OR videos.organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = ?)
+		OR videos.id IN (SELECT video_id FROM video_shares WHERE user_id = ?)
+		OR videos.visibility = ?
+	)`
+
+// SearchTags returns up to limit names of tags starting with prefix that are
+// on videos the user may find, most used first. Tags only used on videos they
+// can't see aren't revealed.
+func (c Client) SearchTags(userID uuid.UUID, prefix string, limit int) ([]string, error) {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	query := `
+	SELECT t.name
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	JOIN videos ON videos.id = vt.video_id
+	WHERE t.name LIKE ? ESCAPE '\' AND ` + visibleVideosFilter + `
+	GROUP BY t.id
+	ORDER BY COUNT(vt.video_id) DESC, t.name
+	LIMIT ?
+	`
+	id := userID.String()
+	return c.queryTagNames(query, escaper.Replace(prefix)+"%", id, id, id, VisibilityPublic, limit)
+}
+
+// GetVideosByTag returns the videos carrying the named tag that the user may
+// find.
+func (c Client) GetVideosByTag(userID uuid.UUID, name string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN video_tags vt ON vt.video_id = videos.id
+	JOIN tags t ON t.id = vt.tag_id
+	WHERE ` + visibleVideosFilter + ` AND t.name = ?
+	ORDER BY videos.created_at DESC
+	`
+	id := userID.String()
+	return c.queryVideos(query, id, id, id, VisibilityPublic, name)
+}
+
+func (c Client) queryTagNames(query string, args ...any) ([]string, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
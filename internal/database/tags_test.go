@@ -0,0 +1,58 @@
+package database
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSearchTagsHidesOtherUsersPrivateTags(t *testing.T) {
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := c.CreateUser(CreateUserParams{Email: "bob@example.com", Password: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		visibility Visibility
+		tag        string
+	}{
+		{VisibilityPrivate, "launch-private"},
+		{VisibilityUnlisted, "launch-unlisted"},
+		{VisibilityPublic, "launch-public"},
+	} {
+		video, err := c.CreateVideo(CreateVideoParams{Title: v.tag, Visibility: v.visibility, UserID: bob.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddVideoTags(video.ID, []string{v.tag}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		user *User
+		want []string
+	}{
+		{"owner", bob, []string{"launch-private", "launch-public", "launch-unlisted"}},
+		{"other user", alice, []string{"launch-public"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.SearchTags(tt.user.ID, "launch", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SearchTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTOTP is a user's authenticator app enrollment. It only protects logins
+// once EnabledAt is set, after the user has proved their app works.
+type UserTOTP struct {
+	UserID uuid.UUID
+	// Secret is shown to the user once, when they enroll.
+	Secret    string `json:"-"`
+	CreatedAt time.Time
+	EnabledAt *time.Time
+	// LastStep is the time step of the last code accepted, so that a code
+	// can't be used twice.
+	LastStep int64
+}
+
+func (t UserTOTP) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+// GetUserTOTP returns the user's enrollment, or a zero UserTOTP if they have
+// none.
+func (c Client) GetUserTOTP(userID uuid.UUID) (UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, created_at, enabled_at, last_step
+		FROM user_totp
+		WHERE user_id = ?
+	`
+	var t UserTOTP
+	var id string
+	err := c.db.QueryRow(query, userID.String()).Scan(&id, &t.Secret, &t.CreatedAt, &t.EnabledAt, &t.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserTOTP{}, nil
+	}
+	if err != nil {
+		return UserTOTP{}, err
+	}
+	t.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return UserTOTP{}, err
+	}
+	return t, nil
+}
+
+// SetPendingUserTOTP starts an enrollment with secret, replacing any earlier
+// unconfirmed one. It does nothing if 2FA is already enabled.
+func (c Client) SetPendingUserTOTP(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at, last_step)
+		VALUES (?, ?, CURRENT_TIMESTAMP, 0)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = excluded.created_at,
+			last_step = 0
+		WHERE enabled_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), secret)
+	return err
+}
+
+// EnableUserTOTP confirms the user's pending enrollment, recording step as
+// used, and replaces their recovery codes with the given hashes.
+func (c Client) EnableUserTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_totp
+		SET enabled_at = CURRENT_TIMESTAMP, last_step = ?
+		WHERE user_id = ?
+	`, step, userID.String())
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It returns false if a
+// code for this step or a later one was already used.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE user_totp
+		SET last_step = ?
+		WHERE user_id = ? AND last_step < ?
+	`, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteUserTOTP turns off 2FA for the user and deletes their recovery codes.
+func (c Client) DeleteUserTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID.String()); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for new ones.
+func (c Client) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx execer, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String()); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode uses up one of the user's recovery codes. It returns
+// false if the code doesn't exist or was already used.
+func (c Client) ConsumeRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func (c Client) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var n int
+	err := c.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`, userID.String()).Scan(&n)
+	return n, err
+}
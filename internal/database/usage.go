@@ -0,0 +1,36 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// StorageUsage is how much a user is storing.
+type StorageUsage struct {
+	Bytes  int64 `json:"bytes"`
+	Videos int   `json:"videos"`
+}
+
+// GetStorageUsage adds up the media of every video the user owns, in their
+// personal library and in organizations.
+func (c Client) GetStorageUsage(userID uuid.UUID) (StorageUsage, error) {
+	query := `
+	SELECT COALESCE(SUM(video_size + thumbnail_size), 0), COUNT(*)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var usage StorageUsage
+	err := c.db.QueryRow(query, userID.String()).Scan(&usage.Bytes, &usage.Videos)
+	return usage, err
+}
+
+// SetUserQuota overrides the user's storage quota. A nil limit means the
+// default applies.
+func (c Client) SetUserQuota(userID uuid.UUID, quotaBytes *int64, quotaVideos *int) error {
+	query := `
+		UPDATE users
+		SET quota_bytes = ?, quota_videos = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, quotaBytes, quotaVideos, userID.String())
+	return err
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTokenPurpose is what a single-use user token may be redeemed for.
+type UserTokenPurpose string
+
+const (
+	UserTokenVerifyEmail   UserTokenPurpose = "verify_email"
+	UserTokenResetPassword UserTokenPurpose = "reset_password"
+	// UserTokenMFALogin is issued after a correct password and redeemed with
+	// a second factor to finish logging in.
+	UserTokenMFALogin UserTokenPurpose = "mfa_login"
+	// UserTokenReauth is emailed to users without a password so they can
+	// confirm sensitive account changes.
+	UserTokenReauth UserTokenPurpose = "reauth"
+	// UserTokenSSOLogin is handed to the web app after a single sign-on login
+	// and redeemed for a session.
+	UserTokenSSOLogin UserTokenPurpose = "sso_login"
+)
+
+// UserToken is a single-use, expiring token emailed to a user. Only a hash of
+// the token is stored.
+type UserToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	UserID    uuid.UUID
+	Purpose   UserTokenPurpose
+	// Email is the address the token was sent to, for tokens that prove the
+	// user owns it, and empty for others.
+	Email string
+}
+
+func (c Client) CreateUserToken(userID uuid.UUID, purpose UserTokenPurpose, email, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO user_tokens (token_hash, created_at, expires_at, user_id, purpose, email)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, tokenHash, expiresAt, userID.String(), purpose, email)
+	return err
+}
+
+// GetUserToken returns the token without using it up. A zero UserToken is
+// returned if the token doesn't exist, is for another purpose, has expired or
+// was already used.
+func (c Client) GetUserToken(tokenHash string, purpose UserTokenPurpose) (UserToken, error) {
+	var token UserToken
+	var userID string
+	err := c.db.QueryRow(`
+		SELECT token_hash, created_at, expires_at, used_at, user_id, purpose, email
+		FROM user_tokens
+		WHERE token_hash = ? AND purpose = ?
+	`, tokenHash, purpose).Scan(&token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &userID, &token.Purpose, &token.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserToken{}, nil
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+	if token.UsedAt != nil || !time.Now().Before(token.ExpiresAt) {
+		return UserToken{}, nil
+	}
+	token.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UserToken{}, err
+	}
+	return token, nil
+}
+
+// ConsumeUserToken marks a token as used and returns it. A zero UserToken is
+// returned if the token doesn't exist, is for another purpose, has expired or
+// was already used.
+func (c Client) ConsumeUserToken(tokenHash string, purpose UserTokenPurpose) (UserToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return UserToken{}, err
+	}
+	defer tx.Rollback()
+
+	var token UserToken
+	var userID string
+	err = tx.QueryRow(`
+		SELECT token_hash, created_at, expires_at, used_at, user_id, purpose, email
+		FROM user_tokens
+		WHERE token_hash = ? AND purpose = ?
+	`, tokenHash, purpose).Scan(&token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &userID, &token.Purpose, &token.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserToken{}, nil
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+	if token.UsedAt != nil || !time.Now().Before(token.ExpiresAt) {
+		return UserToken{}, nil
+	}
+	token.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UserToken{}, err
+	}
+
+	// the used_at check keeps two concurrent redemptions from both succeeding
+	result, err := tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL
+	`, tokenHash)
+	if err != nil {
+		return UserToken{}, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return UserToken{}, err
+	}
+	if n == 0 {
+		return UserToken{}, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return UserToken{}, err
+	}
+	return token, nil
+}
+
+// InvalidateUserTokens uses up the user's outstanding tokens for purpose, so
+// that only the newest token issued, or none, works.
+func (c Client) InvalidateUserTokens(userID uuid.UUID, purpose UserTokenPurpose) error {
+	query := `
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), purpose)
+	return err
+}
@@ -9,23 +9,70 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Role       UserRole   `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	// EmailVerifiedAt is when the user proved they own their email address.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// QuotaBytes and QuotaVideos override the default storage quota when
+	// set.
+	QuotaBytes  *int64 `json:"quota_bytes"`
+	QuotaVideos *int   `json:"quota_videos"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email string `json:"email"`
+	// Password is the argon2id hash, or empty for users who only sign in
+	// through SSO. It must never be sent to clients.
+	Password string `json:"-"`
+}
+
+// UserRole is a user's platform-wide role.
+type UserRole string
+
+const (
+	UserRoleUser  UserRole = "user"
+	UserRoleAdmin UserRole = "admin"
+)
+
+const userColumns = `
+		users.id,
+		users.created_at,
+		users.updated_at,
+		users.email,
+		users.password,
+		users.role,
+		users.disabled_at,
+		users.email_verified_at,
+		users.quota_bytes,
+		users.quota_videos
+`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+		&user.DisabledAt,
+		&user.EmailVerifiedAt,
+		&user.QuotaBytes,
+		&user.QuotaVideos,
+	)
+	return user, err
 }
 
 func (c Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
+		SELECT` + userColumns + `
 		FROM users
+		ORDER BY created_at
 	`
 
 	rows, err := c.db.Query(query)
@@ -36,66 +83,43 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT` + userColumns + `
 		FROM users
 		WHERE email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
+// GetUserByRefreshToken returns the user holding token, or nil if the token
+// doesn't exist or can no longer be used.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
-	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
-		FROM users u
-		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
-	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	rt, err := c.GetRefreshToken(token)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
+	if rt.Token == "" || !rt.Active(time.Now()) {
+		return nil, nil
 	}
-
-	return &user, nil
+	return c.GetUser(rt.UserID)
 }
 
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
@@ -117,31 +141,169 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT` + userColumns + `
 		FROM users
 		WHERE id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+func (c Client) SetUserRole(id uuid.UUID, role UserRole) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// SetUserDisabled disables the account at disabledAt, or re-enables it when
+// disabledAt is nil.
+func (c Client) SetUserDisabled(id uuid.UUID, disabledAt *time.Time) error {
+	query := `
+		UPDATE users
+		SET disabled_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, disabledAt, id.String())
+	return err
+}
+
+// DeleteUser deletes the user and everything that belongs only to them: their
+// personal videos, playlists and credentials, and organizations they are the
+// only member of. Videos they uploaded to other organizations stay with the
+// organization. It returns the deleted videos so their media can be removed.
+func (c Client) DeleteUser(id uuid.UUID) ([]Video, error) {
+	tx, err := c.db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	defer tx.Rollback()
+
+	// organizations with no one else in them go with the user
+	soleOrgs := `
+		SELECT organization_id
+		FROM organization_members
+		GROUP BY organization_id
+		HAVING COUNT(*) = 1 AND MAX(user_id) = ?
+	`
+	rows, err := tx.Query(soleOrgs, id)
+	if err != nil {
+		return nil, err
+	}
+	orgIDs := []uuid.UUID{}
+	for rows.Next() {
+		var orgID uuid.UUID
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT`+videoColumns+`
+		FROM videos
+		WHERE (user_id = ? AND organization_id IS NULL) OR organization_id IN (`+soleOrgs+`)
+	`, id, id)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, video := range videos {
+		if err := deleteVideo(tx, video.ID); err != nil {
+			return nil, err
+		}
+	}
+	for _, orgID := range orgIDs {
+		if _, err := tx.Exec(`DELETE FROM organization_members WHERE organization_id = ?`, orgID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM organizations WHERE id = ?`, orgID); err != nil {
+			return nil, err
+		}
+	}
+
+	statements := []string{
+		`DELETE FROM playlist_videos WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)`,
+		`DELETE FROM playlists WHERE user_id = ?`,
+		`DELETE FROM video_shares WHERE user_id = ?`,
+		`DELETE FROM organization_members WHERE user_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM user_tokens WHERE user_id = ?`,
+		`DELETE FROM user_totp WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+// SetUserEmail changes the user's email address. The new address hasn't been
+// verified yet.
+func (c Client) SetUserEmail(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET email = ?, email_verified_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, email, id.String())
+	return err
+}
+
+// SetUserEmailVerified records that the user has proved they own email, as
+// long as it's still their address. It returns false if it isn't.
+func (c Client) SetUserEmailVerified(id uuid.UUID, email string) (bool, error) {
+	query := `
+		UPDATE users
+		SET
+			email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP),
+			updated_at = CASE WHEN email_verified_at IS NULL THEN CURRENT_TIMESTAMP ELSE updated_at END
+		WHERE id = ? AND email = ?
+	`
+	result, err := c.db.Exec(query, id.String(), email)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) SetUserPassword(id uuid.UUID, hashedPassword string) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.Exec(query, hashedPassword, id.String())
 	return err
 }
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareRole is the access a video's owner grants to another user.
+type ShareRole string
+
+const (
+	// ShareRoleViewer can see the video even when it is private.
+	ShareRoleViewer ShareRole = "viewer"
+	// ShareRoleEditor can additionally edit metadata and upload media.
+	ShareRoleEditor ShareRole = "editor"
+)
+
+func (r ShareRole) Valid() bool {
+	return r == ShareRoleViewer || r == ShareRoleEditor
+}
+
+type VideoShare struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      ShareRole `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareVideo grants userID the role on the video, replacing any earlier grant.
+func (c Client) ShareVideo(videoID, userID uuid.UUID, role ShareRole) error {
+	query := `
+	INSERT INTO video_shares (video_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, videoID, userID, role)
+	return err
+}
+
+func (c Client) UnshareVideo(videoID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM video_shares
+	WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, videoID, userID)
+	return err
+}
+
+func (c Client) GetVideoShares(videoID uuid.UUID) ([]VideoShare, error) {
+	query := `
+	SELECT vs.video_id, vs.user_id, u.email, vs.role, vs.created_at
+	FROM video_shares vs
+	JOIN users u ON u.id = vs.user_id
+	WHERE vs.video_id = ?
+	ORDER BY vs.created_at
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []VideoShare{}
+	for rows.Next() {
+		var share VideoShare
+		if err := rows.Scan(&share.VideoID, &share.UserID, &share.Email, &share.Role, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// GetVideoShareRole returns the role userID was granted on the video, or an
+// empty role if the video isn't shared with them.
+func (c Client) GetVideoShareRole(videoID, userID uuid.UUID) (ShareRole, error) {
+	query := `
+	SELECT role
+	FROM video_shares
+	WHERE video_id = ? AND user_id = ?
+	`
+	var role ShareRole
+	err := c.db.QueryRow(query, videoID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// GetVideosSharedWith returns other users' videos shared with userID.
+func (c Client) GetVideosSharedWith(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN video_shares vs ON vs.video_id = videos.id
+	WHERE vs.user_id = ?
+	ORDER BY videos.created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
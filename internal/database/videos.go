@@ -8,62 +8,186 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrVideoModified is returned when a conditional update finds that the video
+// changed since the caller last read it.
+var ErrVideoModified = errors.New("video was modified concurrently")
+
+// ErrVideoNotFound is returned when a conditional update finds that the video
+// has been deleted.
+var ErrVideoNotFound = errors.New("video not found")
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	// VideoSize and ThumbnailSize are the stored media sizes in bytes,
+	// counted towards the owner's storage quota.
+	VideoSize     int64 `json:"video_size"`
+	ThumbnailSize int64 `json:"thumbnail_size"`
+	// VideoSHA256 is the hex SHA-256 of the stored video, for clients to
+	// check downloads against.
+	VideoSHA256 *string `json:"video_sha256"`
+	// Status is rejected when an upload was turned away, such as by the
+	// malware scanner, with RejectionReason saying why.
+	Status          VideoStatus `json:"status"`
+	RejectionReason *string     `json:"rejection_reason"`
+	Tags            []string    `json:"tags"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Visibility  Visibility `json:"visibility"`
+	UserID      uuid.UUID  `json:"user_id"`
+	// OrganizationID is the workspace that owns the video, or nil for videos
+	// in the uploader's personal library.
+	OrganizationID *uuid.UUID `json:"organization_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+// VideoStatus says whether a video's latest upload was accepted.
+type VideoStatus string
+
+const (
+	VideoStatusActive   VideoStatus = "active"
+	VideoStatusRejected VideoStatus = "rejected"
+)
+
+// Visibility controls who can see a video.
+type Visibility string
+
+const (
+	// VisibilityPrivate videos are only visible to their owner.
+	VisibilityPrivate Visibility = "private"
+	// VisibilityUnlisted videos are visible to anyone with the link.
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPublic videos are visible to anyone and listed in the public feed.
+	VisibilityPublic Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
 
-	rows, err := c.db.Query(query, userID)
+const videoColumns = `
+		videos.id,
+		videos.created_at,
+		videos.updated_at,
+		videos.title,
+		videos.description,
+		videos.thumbnail_url,
+		videos.video_url,
+		videos.video_size,
+		videos.thumbnail_size,
+		videos.video_sha256,
+		videos.status,
+		videos.rejection_reason,
+		videos.visibility,
+		videos.user_id,
+		videos.organization_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.VideoSize,
+		&video.ThumbnailSize,
+		&video.VideoSHA256,
+		&video.Status,
+		&video.RejectionReason,
+		&video.Visibility,
+		&video.UserID,
+		&video.OrganizationID,
+	)
+	return video, err
+}
+
+// queryVideos runs a query selecting videoColumns and loads each video's tags.
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	videos, err := scanVideos(rows)
 	if err != nil {
 		return nil, err
 	}
+
+	for i := range videos {
+		videos[i].Tags, err = c.GetVideoTags(videos[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return videos, nil
+}
+
+// scanVideos reads every row, without tags, and closes rows.
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
 
-	return videos, nil
+// GetVideos returns the videos in the user's personal library.
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND organization_id IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetPublicVideos returns a page of public videos from all users, newest first.
+func (c Client) GetPublicVideos(limit, offset int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE visibility = ?
+	ORDER BY created_at DESC
+	LIMIT ? OFFSET ?
+	`
+	return c.queryVideos(query, VisibilityPublic, limit, offset)
+}
+
+// IsPublicThumbnail reports whether a public video uses the thumbnail at
+// thumbnailURL, so that it can be served to anyone.
+func (c Client) IsPublicThumbnail(thumbnailURL string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM videos WHERE thumbnail_url = ? AND visibility = ?
+	)
+	`
+	var public bool
+	err := c.db.QueryRow(query, thumbnailURL, VisibilityPublic).Scan(&public)
+	return public, err
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +199,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		visibility,
+		user_id,
+		organization_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID, params.OrganizationID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +214,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,27 +227,85 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	video.Tags, err = c.GetVideoTags(video.ID)
+	if err != nil {
+		return Video{}, err
+	}
 	return video, nil
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	return updateVideo(c.db, video)
+}
+
+// UpdateVideoIfUnmodified saves the video only if its stored updated_at still
+// equals unmodifiedSince, returning ErrVideoModified otherwise, or
+// ErrVideoNotFound if it's gone.
+func (c Client) UpdateVideoIfUnmodified(video Video, unmodifiedSince time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var updatedAt time.Time
+	err = tx.QueryRow(`SELECT updated_at FROM videos WHERE id = ?`, video.ID).Scan(&updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrVideoNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !updatedAt.Equal(unmodifiedSince) {
+		return ErrVideoModified
+	}
+
+	if err := updateVideo(tx, video); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func updateVideo(db execer, video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
+		video_size = ?,
+		thumbnail_size = ?,
+		video_sha256 = ?,
+		status = ?,
+		rejection_reason = ?,
+		visibility = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err := db.Exec(
 		query,
+		video.UpdatedAt,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		video.VideoSize,
+		video.ThumbnailSize,
+		video.VideoSHA256,
+		video.Status,
+		video.RejectionReason,
+		video.Visibility,
 		video.UserID,
 		video.ID,
 	)
@@ -146,10 +313,24 @@ func (c Client) UpdateVideo(video Video) error {
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	return deleteVideo(c.db, id)
+}
+
+func deleteVideo(db execer, id uuid.UUID) error {
+	if _, err := db.Exec(`DELETE FROM video_tags WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM playlist_videos WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM video_shares WHERE video_id = ?`, id); err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := db.Exec(query, id)
 	return err
 }
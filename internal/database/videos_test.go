@@ -0,0 +1,38 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateVideoIfUnmodified(t *testing.T) {
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "launch", Visibility: VisibilityPrivate, UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := video.UpdatedAt
+
+	video.Title = "first"
+	video.UpdatedAt = time.Now().UTC()
+	if err := c.UpdateVideoIfUnmodified(video, read); err != nil {
+		t.Fatalf("UpdateVideoIfUnmodified() = %v", err)
+	}
+	video.Title = "stale"
+	if err := c.UpdateVideoIfUnmodified(video, read); !errors.Is(err, ErrVideoModified) {
+		t.Errorf("UpdateVideoIfUnmodified() with a stale read = %v, want ErrVideoModified", err)
+	}
+	if err := c.DeleteVideo(video.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateVideoIfUnmodified(video, video.UpdatedAt); !errors.Is(err, ErrVideoNotFound) {
+		t.Errorf("UpdateVideoIfUnmodified() after deleting = %v, want ErrVideoNotFound", err)
+	}
+}
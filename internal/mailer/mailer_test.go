@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSMTPMailerGivesUpOnHungServer(t *testing.T) {
+	// the server accepts connections but never greets the client
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+	}{
+		{"deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}},
+		{"cancellation", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- SMTPMailer{Addr: listener.Addr().String(), From: "tubely@example.com"}.Send(ctx, Message{To: "alice@example.com"})
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("Send() succeeded against a server that never answered")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Send() is still waiting for the server")
+			}
+		})
+	}
+}
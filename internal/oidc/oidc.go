@@ -0,0 +1,311 @@
+// Package oidc implements the parts of OpenID Connect that Tubely needs to let
+// users sign in with an external identity provider: discovery, the
+// authorization code flow with PKCE, and ID token validation.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config identifies Tubely to the identity provider.
+type Config struct {
+	// Issuer is the provider's issuer URL; the discovery document is fetched
+	// from Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is Tubely's callback URL registered with the provider.
+	RedirectURL string
+	// Scopes requested in addition to "openid".
+	Scopes []string
+}
+
+// Claims are the parts of a validated ID token Tubely uses.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider. Its discovery document and keys
+// are fetched on first use and cached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *discoveryDocument
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// keysRefetchInterval limits how often unknown key IDs can make us refetch
+// the provider's key set.
+const keysRefetchInterval = time.Minute
+
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer identifies the provider in the accounts it vouches for.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	doc := discoveryDocument{}
+	if err := p.getJSON(ctx, wellKnown, &doc); err != nil {
+		return nil, fmt.Errorf("couldn't fetch discovery document: %w", err)
+	}
+	if doc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, expected %q", doc.Issuer, p.config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the provider URL to send the user to. state and nonce
+// are echoed back to tie the response to this request, and challenge is the
+// PKCE challenge for the verifier that will be passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the user's ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's keys,
+// and that it was issued by the provider, for us, for this login attempt.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims := idTokenClaims{}
+	_, err = jwt.ParseWithClaims(
+		rawIDToken,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.key(ctx, doc.JWKSURI, kid, token.Method.Alg())
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.ExpiresAt == nil {
+		return Claims{}, errors.New("ID token has no expiry")
+	}
+	if claims.Nonce != nonce {
+		return Claims{}, errors.New("ID token nonce doesn't match")
+	}
+	if claims.Subject == "" {
+		return Claims{}, errors.New("ID token has no subject")
+	}
+
+	// some providers send email_verified as a string
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+	return Claims{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+	}, nil
+}
+
+// key returns the provider's public key with the given ID, refetching the key
+// set once if it isn't known, since the provider may have rotated its keys.
+func (p *Provider) key(ctx context.Context, jwksURI, kid, alg string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := findKey(p.keys, kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keysRefetchInterval {
+		return nil, fmt.Errorf("no provider key %q for %s", kid, alg)
+	}
+	jwks := jsonWebKeySet{}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("couldn't fetch provider keys: %w", err)
+	}
+	keys := map[string]any{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := findKey(p.keys, kid)
+	if !ok {
+		return nil, fmt.Errorf("no provider key %q for %s", kid, alg)
+	}
+	return key, nil
+}
+
+// findKey looks a key up by ID. Tokens without a kid are accepted only when
+// the provider has a single key.
+func findKey(keys map[string]any, kid string) (any, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a URL-safe random string for states, nonces and PKCE
+// verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge derives the S256 code challenge for a verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
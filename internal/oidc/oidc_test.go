@@ -0,0 +1,108 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://tubely.test/api/oidc/callback"
+
+// authorize sends the user to the identity provider and returns the code it
+// redirects back with.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, oidc.PKCEChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %s", resp.Status)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state is %q, want %q", got, state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestLogin(t *testing.T) {
+	idp := oidctest.NewServer(t, "tubely")
+	idp.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:      idp.URL,
+		ClientID:    "tubely",
+		RedirectURL: redirectURL,
+		Scopes:      []string{"email"},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		verifier    string
+		nonce       string
+		exchangeErr bool
+		verifyErr   bool
+	}{
+		{name: "valid", verifier: "verifier", nonce: "nonce"},
+		{name: "wrong PKCE verifier", verifier: "other verifier", nonce: "nonce", exchangeErr: true},
+		{name: "wrong nonce", verifier: "verifier", nonce: "other nonce", verifyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := authorize(t, provider, "state", "nonce", "verifier")
+
+			rawIDToken, err := provider.Exchange(ctx, code, tt.verifier)
+			if (err != nil) != tt.exchangeErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.exchangeErr)
+			}
+			if err != nil {
+				return
+			}
+
+			claims, err := provider.VerifyIDToken(ctx, rawIDToken, tt.nonce)
+			if (err != nil) != tt.verifyErr {
+				t.Fatalf("VerifyIDToken() error = %v, wantErr %v", err, tt.verifyErr)
+			}
+			if err != nil {
+				return
+			}
+			want := oidc.Claims{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true}
+			if claims != want {
+				t.Errorf("claims = %+v, want %+v", claims, want)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherAudience(t *testing.T) {
+	idp := oidctest.NewServer(t, "someone-else")
+	idp.SetUser(oidctest.User{Subject: "alice-sub"})
+	other := oidc.NewProvider(oidc.Config{Issuer: idp.URL, ClientID: "someone-else", RedirectURL: redirectURL})
+	ours := oidc.NewProvider(oidc.Config{Issuer: idp.URL, ClientID: "tubely", RedirectURL: redirectURL})
+	ctx := context.Background()
+
+	code := authorize(t, other, "state", "nonce", "verifier")
+	rawIDToken, err := other.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := ours.VerifyIDToken(ctx, rawIDToken, "nonce"); err == nil {
+		t.Error("accepted an ID token issued to another client")
+	}
+}
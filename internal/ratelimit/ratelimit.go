@@ -0,0 +1,142 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// storage, so limits can be kept in process or shared between servers.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests requests per Period. Unused allowance builds up to a
+// burst of Requests, and is refilled evenly over the period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit written as "<requests>/<period>", such as
+// "100/1m".
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q must look like 100/1m", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("limit %q must allow a positive number of requests", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("limit %q must have a positive period", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// rate is how many tokens are added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result describes a request's effect on its bucket.
+type Result struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is how many more requests are allowed right now.
+	Remaining int
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+	// RetryAfter is how long until a request would be allowed, if this one
+	// wasn't.
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets. Take spends a token from key's bucket if it has one.
+// Implementations backed by a shared cache let several servers enforce one
+// limit.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// period is the limit's period; an unused bucket is full again after it.
+	period time.Duration
+}
+
+// take refills the bucket for the time since it was last used, then spends
+// a token if there is one.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*limit.rate())
+		b.updatedAt = now
+	}
+	b.period = limit.Period
+
+	result := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = seconds((capacity - b.tokens) / limit.rate())
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// MemoryStore keeps buckets in process. Each server enforces its own limits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepInterval is how often full buckets are dropped to bound memory use.
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		s.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+// sweep drops buckets that have been idle long enough to refill, since a
+// missing bucket is treated as full.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if now.Sub(b.updatedAt) > b.period {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,126 @@
+// Package scanner checks uploaded files for malware before they're stored.
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of scanning a file.
+type Result struct {
+	Infected bool
+	// Signature names what was found in an infected file.
+	Signature string
+}
+
+// Scanner scans files.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// NoopScanner reports every file as clean, for when no scanner is set up.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{}, nil
+}
+
+// clamAVChunkSize is how much of the file is sent to clamd at a time.
+const clamAVChunkSize = 64 << 10
+
+// ClamAVScanner streams files to a clamd daemon with its INSTREAM command.
+// Files larger than clamd's StreamMaxLength are refused with an error.
+type ClamAVScanner struct {
+	// Addr is the path of clamd's unix socket, or the host:port it listens
+	// on over TCP.
+	Addr string
+	// Timeout bounds each scan, including the connection. Zero means no
+	// limit beyond the context's.
+	Timeout time.Duration
+}
+
+func (s ClamAVScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(s.Addr, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, s.Addr)
+	if err != nil {
+		return Result{}, fmt.Errorf("couldn't connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// clamd stops reading and replies as soon as it finds a problem, such as
+	// the stream getting too long, so a failed write may still have a reply
+	writeErr := sendInstream(conn, r)
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if writeErr != nil {
+			return Result{}, fmt.Errorf("couldn't send file to clamd: %w", writeErr)
+		}
+		return Result{}, fmt.Errorf("couldn't read clamd reply: %w", err)
+	}
+	return parseClamAVReply(strings.TrimRight(reply, "\x00"))
+}
+
+// sendInstream sends r as a stream of length-prefixed chunks, ending with an
+// empty one.
+func sendInstream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamAVChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamAVReply reads a reply like "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseClamAVReply(reply string) (Result, error) {
+	_, status, ok := strings.Cut(reply, ": ")
+	if !ok {
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,148 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClamAVReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    Result
+		wantErr bool
+	}{
+		{reply: "stream: OK", want: Result{}},
+		{reply: "stream: Eicar-Test-Signature FOUND", want: Result{Infected: true, Signature: "Eicar-Test-Signature"}},
+		{reply: "stream: Can't allocate memory ERROR", wantErr: true},
+		{reply: "INSTREAM size limit exceeded. ERROR", wantErr: true},
+		{reply: "UNKNOWN COMMAND", wantErr: true},
+		{reply: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseClamAVReply(tt.reply)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseClamAVReply(%q) = %+v, %v; want %+v, error %v", tt.reply, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// instream is what a fake clamd received.
+type instream struct {
+	chunkSizes []int
+	data       []byte
+	// terminated is whether the stream ended with an empty chunk.
+	terminated bool
+}
+
+// fakeClamd answers a single INSTREAM command on a unix socket. Once the
+// stream is longer than maxLength it stops reading and refuses it, as clamd
+// does past its StreamMaxLength.
+func fakeClamd(t *testing.T, maxLength int) (string, <-chan instream) {
+	t.Helper()
+	addr := filepath.Join(t.TempDir(), "clamd.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan instream, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		var got instream
+		defer func() { received <- got }()
+		command, err := r.ReadString(0)
+		if err != nil || command != "zINSTREAM\x00" {
+			io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				got.terminated = true
+				io.WriteString(conn, "stream: OK\x00")
+				return
+			}
+			got.chunkSizes = append(got.chunkSizes, int(size))
+			if len(got.data)+int(size) > maxLength {
+				io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+				// keep the connection open until the client has the reply
+				io.Copy(io.Discard, r)
+				return
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			got.data = append(got.data, chunk...)
+		}
+	}()
+	return addr, received
+}
+
+func TestClamAVScannerStreamsChunks(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		chunkSizes []int
+	}{
+		{"empty", 0, nil},
+		{"one chunk", clamAVChunkSize, []int{clamAVChunkSize}},
+		{"partial last chunk", 2*clamAVChunkSize + 100, []int{clamAVChunkSize, clamAVChunkSize, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := bytes.Repeat([]byte("t"), tt.size)
+			addr, received := fakeClamd(t, 1<<20)
+
+			scanner := ClamAVScanner{Addr: addr, Timeout: 5 * time.Second}
+			result, err := scanner.Scan(context.Background(), bytes.NewReader(file))
+			if err != nil || result.Infected {
+				t.Fatalf("Scan() = %+v, %v; want clean", result, err)
+			}
+
+			got := <-received
+			if !slices.Equal(got.chunkSizes, tt.chunkSizes) {
+				t.Errorf("got chunks of %v bytes, want %v", got.chunkSizes, tt.chunkSizes)
+			}
+			if !bytes.Equal(got.data, file) {
+				t.Error("clamd got different bytes than were scanned")
+			}
+			if !got.terminated {
+				t.Error("stream didn't end with an empty chunk")
+			}
+		})
+	}
+}
+
+func TestClamAVScannerReportsSizeLimit(t *testing.T) {
+	addr, received := fakeClamd(t, clamAVChunkSize)
+
+	scanner := ClamAVScanner{Addr: addr, Timeout: 5 * time.Second}
+	file := bytes.Repeat([]byte{0}, 4*clamAVChunkSize)
+	_, err := scanner.Scan(context.Background(), bytes.NewReader(file))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan() = %v, want clamd's size limit error", err)
+	}
+	if got := <-received; got.terminated {
+		t.Error("clamd read the whole stream despite the limit")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// secretFields must never appear in a response body, at any depth.
+var secretFields = []string{"password", "secret", "key_hash", "token_hash"}
+
+// assertNoSecretFields decodes a JSON response and fails if any object in it
+// has one of secretFields as a key.
+func assertNoSecretFields(t *testing.T, name string, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	var body any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: couldn't decode response %q: %v", name, rec.Body, err)
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for _, field := range secretFields {
+				if _, ok := v[field]; ok {
+					t.Errorf("%s: response has a %q field: %s", name, field, rec.Body)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(body)
+}
+
+func TestResponsesHaveNoSecretFields(t *testing.T) {
+	cfg := newTestConfig(t)
+	credentials := map[string]string{"email": "alice@example.com", "password": "correct horse"}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.handlerUsersCreate), "POST", "/api/users", "", credentials)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got status %d: %s", rec.Code, rec.Body)
+	}
+	assertNoSecretFields(t, "signup", rec)
+
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admin endpoints are tested with alice's own session
+	if err := cfg.db.SetUserRole(alice.ID, database.UserRoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.handlerLogin), "POST", "/api/login", "", credentials)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got status %d: %s", rec.Code, rec.Body)
+	}
+	assertNoSecretFields(t, "login", rec)
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &login); err != nil {
+		t.Fatal(err)
+	}
+
+	signUpAndLogIn(t, cfg, "bob@example.com", "battery staple")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/users/me", cfg.authMiddleware(auth.ScopeVideosRead, cfg.handlerUserMeGet))
+	mux.Handle("POST /api/api_keys", cfg.authMiddleware(auth.ScopeVideosRead, cfg.handlerAPIKeyCreate))
+	mux.Handle("GET /api/api_keys", cfg.authMiddleware(auth.ScopeVideosRead, cfg.handlerAPIKeysRetrieve))
+	mux.Handle("GET /api/sessions", cfg.authMiddleware(auth.ScopeVideosRead, cfg.handlerSessionsRetrieve))
+	mux.Handle("GET /admin/users", cfg.adminMiddleware(cfg.handlerAdminUsersRetrieve))
+	mux.Handle("PUT /admin/users/{userID}/quota", cfg.adminMiddleware(cfg.handlerAdminUserQuotaSet))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   any
+		status int
+	}{
+		{"get profile", "GET", "/api/users/me", nil, http.StatusOK},
+		{"create API key", "POST", "/api/api_keys", map[string]any{"name": "ci", "scopes": []string{auth.ScopeVideosRead}}, http.StatusCreated},
+		{"list API keys", "GET", "/api/api_keys", nil, http.StatusOK},
+		{"list sessions", "GET", "/api/sessions", nil, http.StatusOK},
+		{"admin list users", "GET", "/admin/users", nil, http.StatusOK},
+		{"admin set quota", "PUT", "/admin/users/" + alice.ID.String() + "/quota", map[string]any{"bytes": 1 << 20}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doJSON(t, mux, tt.method, tt.target, login.Token, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			assertNoSecretFields(t, tt.name, rec)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// maxLoginLockout caps how long repeated lockouts can grow.
+const maxLoginLockout = 24 * time.Hour
+
+// loginLimits controls how many failed logins are tolerated before an account
+// or client address is locked out.
+type loginLimits struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	// Window is how long failures are remembered after the last one, or after
+	// a lockout ends.
+	Window time.Duration
+	// Lockout is the first lockout's length; each further lockout doubles it.
+	Lockout time.Duration
+}
+
+// loginThrottleKeys returns the keys failed logins are counted against.
+// Unknown emails are counted too, so lockouts don't reveal who has an account.
+func loginThrottleKeys(r *http.Request, email string) (account, ip string) {
+	return "account:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + clientIP(r)
+}
+
+// checkLoginLockout responds with 429 and returns false if either the account
+// or the client is locked out.
+func (cfg *apiConfig) checkLoginLockout(w http.ResponseWriter, keys ...string) bool {
+	now := time.Now()
+	var lockedUntil time.Time
+	for _, key := range keys {
+		f, err := cfg.db.GetLoginFailure(key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+			return false
+		}
+		if f.LockedUntil != nil && f.LockedUntil.After(lockedUntil) {
+			lockedUntil = *f.LockedUntil
+		}
+	}
+	if !now.Before(lockedUntil) {
+		return true
+	}
+
+	w.Header().Set("Retry-After", retryAfterSeconds(lockedUntil.Sub(now)))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts; try again later", nil)
+	return false
+}
+
+// recordLoginFailure counts a failed login against the account and client,
+// locking out whichever has now failed too often.
+func (cfg *apiConfig) recordLoginFailure(accountKey, ipKey string) error {
+	if err := cfg.recordLoginFailureFor(accountKey, cfg.loginLimits.MaxAccountFailures); err != nil {
+		return err
+	}
+	return cfg.recordLoginFailureFor(ipKey, cfg.loginLimits.MaxIPFailures)
+}
+
+func (cfg *apiConfig) recordLoginFailureFor(key string, maxFailures int) error {
+	now := time.Now().UTC()
+	f, err := cfg.db.RecordLoginFailure(key, now, now.Add(-cfg.loginLimits.Window))
+	if err != nil {
+		return err
+	}
+
+	locked := false
+	if f.Failures >= maxFailures {
+		lockout := min(cfg.loginLimits.Lockout<<min(f.Failures-maxFailures, 16), maxLoginLockout)
+		lockedUntil := now.Add(lockout)
+		if err := cfg.db.LockLoginFailures(key, lockedUntil); err != nil {
+			return err
+		}
+		f.LockedUntil = &lockedUntil
+		locked = true
+	}
+
+	if locked {
+		detail := fmt.Sprintf("%d failed logins; locked until %s", f.Failures, f.LockedUntil.Format(time.RFC3339))
+		log.Printf("Login lockout for %s: %s", key, detail)
+		err := cfg.db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+			Action: "login_lockout",
+			Target: key,
+			Detail: detail,
+		})
+		if err != nil {
+			log.Printf("Couldn't record login lockout for %s: %v", key, err)
+		}
+	}
+	return nil
+}
@@ -2,26 +2,51 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scanner"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	jwtKeys          *auth.Keyring
+	jwtAudience      string
+	accessTokenTTL   time.Duration
+	loginLimits      loginLimits
+	defaultQuota     storageQuota
+	uploadLimits     uploadLimits
+	mediaURLTTL      time.Duration
+	mediaURLKey      []byte
+	rateLimits       map[rateLimitClass]ratelimit.Limit
+	rateLimitStore   ratelimit.Store
+	oidcProvider     *oidc.Provider
+	mailer           mailer.Mailer
+	scanner          scanner.Scanner
+	quarantineDir    string
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	cfURLSigner      *sign.URLSigner
 	port             string
 	s3Client         *s3.Client
 }
@@ -44,9 +69,60 @@ func main() {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// tokens are signed with JWT_SECRET unless asymmetric keys are configured;
+	// keys other than the active one keep validating for the rotation window
+	// after they're first retired
+	jwtKeys, err := auth.LoadKeyring(
+		os.Getenv("JWT_KEYS_DIR"),
+		os.Getenv("JWT_ACTIVE_KEY_ID"),
+		os.Getenv("JWT_SECRET"),
+		durationFromEnv("JWT_ROTATION_WINDOW", 24*time.Hour),
+	)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT signing keys: %v", err)
+	}
+
+	jwtAudience := os.Getenv("JWT_AUDIENCE")
+	if jwtAudience == "" {
+		jwtAudience = "tubely"
+	}
+
+	accessTokenTTL := durationFromEnv("ACCESS_TOKEN_TTL", time.Hour)
+
+	loginLimits := loginLimits{
+		MaxAccountFailures: intFromEnv("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures:      intFromEnv("LOGIN_MAX_FAILURES_PER_IP", 20),
+		Window:             durationFromEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Lockout:            durationFromEnv("LOGIN_LOCKOUT", 15*time.Minute),
+	}
+
+	// users without their own quota, set by an admin, get these
+	defaultQuota := storageQuota{
+		Bytes:  int64FromEnv("QUOTA_BYTES", 10<<30),
+		Videos: intFromEnv("QUOTA_VIDEOS", 100),
+	}
+
+	uploadLimits := uploadLimits{
+		Video:     int64FromEnv("UPLOAD_LIMIT_VIDEO", 1<<30),
+		Thumbnail: int64FromEnv("UPLOAD_LIMIT_THUMBNAIL", 10<<20),
+	}
+
+	// videos, and thumbnails of videos that aren't public, are only reachable
+	// through URLs that expire; without MEDIA_URL_SECRET, signed thumbnail
+	// URLs stop working on restart
+	mediaURLTTL := durationFromEnv("MEDIA_URL_TTL", 15*time.Minute)
+	mediaURLKey := []byte(os.Getenv("MEDIA_URL_SECRET"))
+	if len(mediaURLKey) == 0 {
+		mediaURLKey = make([]byte, 32)
+		if _, err := rand.Read(mediaURLKey); err != nil {
+			log.Fatalf("Couldn't generate media URL key: %v", err)
+		}
+	}
+
+	rateLimits := map[rateLimitClass]ratelimit.Limit{
+		rateLimitAuth:   rateLimitFromEnv("RATE_LIMIT_AUTH", ratelimit.Limit{Requests: 20, Period: time.Minute}),
+		rateLimitAPI:    rateLimitFromEnv("RATE_LIMIT_API", ratelimit.Limit{Requests: 600, Period: time.Minute}),
+		rateLimitUpload: rateLimitFromEnv("RATE_LIMIT_UPLOAD", ratelimit.Limit{Requests: 30, Period: time.Hour}),
 	}
 
 	platform := os.Getenv("PLATFORM")
@@ -79,11 +155,113 @@ func main() {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	// the distribution only serves URLs signed with this key, so that links
+	// to videos expire; its origin must not be reachable any other way
+	cfKeyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	if cfKeyPairID == "" {
+		log.Fatal("CF_KEY_PAIR_ID environment variable is not set")
+	}
+	cfPrivateKeyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+	if cfPrivateKeyPath == "" {
+		log.Fatal("CF_PRIVATE_KEY_PATH environment variable is not set")
+	}
+	cfPrivateKey, err := sign.LoadPEMPrivKeyFile(cfPrivateKeyPath)
+	if err != nil {
+		log.Fatalf("Couldn't load CloudFront signing key: %v", err)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	// optional: comma-separated emails of users to promote to admin on startup
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		user, err := db.GetUserByEmail(email)
+		if err != nil {
+			log.Fatalf("Couldn't look up admin %s: %v", email, err)
+		}
+		if user.ID == uuid.Nil {
+			log.Printf("Admin %s has no account yet; restart after they sign up", email)
+			continue
+		}
+		if err := db.SetUserRole(user.ID, database.UserRoleAdmin); err != nil {
+			log.Fatalf("Couldn't promote %s to admin: %v", email, err)
+		}
+	}
+
+	// optional: single sign-on through an OpenID Connect identity provider
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		oidcConfig := oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       []string{"email"},
+		}
+		if oidcConfig.ClientID == "" || oidcConfig.RedirectURL == "" {
+			log.Fatal("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC_ISSUER is")
+		}
+		oidcProvider = oidc.NewProvider(oidcConfig)
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Tubely <no-reply@localhost>"
+	}
+	var mail mailer.Mailer
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		mail = mailer.LogMailer{}
+	case "file":
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "./mail"
+		}
+		mail = mailer.FileMailer{Dir: mailDir, From: mailFrom}
+	case "smtp":
+		smtpAddr := os.Getenv("SMTP_ADDR")
+		if smtpAddr == "" {
+			log.Fatal("SMTP_ADDR must be set when MAILER is smtp")
+		}
+		mail = mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	default:
+		log.Fatalf("Unknown MAILER %q; use log, file or smtp", os.Getenv("MAILER"))
+	}
+
+	// uploads are scanned for malware before they're stored; flagged files
+	// are kept in QUARANTINE_DIR
+	var fileScanner scanner.Scanner
+	switch os.Getenv("SCANNER") {
+	case "", "none":
+		fileScanner = scanner.NoopScanner{}
+	case "clamav":
+		clamAVAddr := os.Getenv("CLAMAV_ADDR")
+		if clamAVAddr == "" {
+			log.Fatal("CLAMAV_ADDR must be set when SCANNER is clamav")
+		}
+		fileScanner = scanner.ClamAVScanner{
+			Addr:    clamAVAddr,
+			Timeout: durationFromEnv("CLAMAV_TIMEOUT", time.Minute),
+		}
+	default:
+		log.Fatalf("Unknown SCANNER %q; use none or clamav", os.Getenv("SCANNER"))
+	}
+	quarantineDir := os.Getenv("QUARANTINE_DIR")
+	if quarantineDir == "" {
+		quarantineDir = "./quarantine"
+	}
+
 	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
 	if err != nil {
 		log.Fatalf("Couldn't load AWS config: %v", err)
@@ -92,13 +270,27 @@ func main() {
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		jwtKeys:          jwtKeys,
+		jwtAudience:      jwtAudience,
+		accessTokenTTL:   accessTokenTTL,
+		loginLimits:      loginLimits,
+		defaultQuota:     defaultQuota,
+		uploadLimits:     uploadLimits,
+		mediaURLTTL:      mediaURLTTL,
+		mediaURLKey:      mediaURLKey,
+		rateLimits:       rateLimits,
+		rateLimitStore:   ratelimit.NewMemoryStore(),
+		oidcProvider:     oidcProvider,
+		mailer:           mail,
+		scanner:          fileScanner,
+		quarantineDir:    quarantineDir,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
+		cfURLSigner:      sign.NewURLSigner(cfKeyPairID, cfPrivateKey),
 		port:             port,
 		s3Client:         s3Client,
 	}
@@ -112,23 +304,89 @@ func main() {
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	assetsHandler := http.StripPrefix("/assets", cfg.signedAssetsMiddleware(http.FileServer(http.Dir(assetsRoot))))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.Handle("GET /.well-known/jwks.json", cfg.rateLimit(rateLimitAPI, cfg.handlerJWKS))
+
+	mux.Handle("POST /api/login", cfg.rateLimit(rateLimitAuth, cfg.handlerLogin))
+	mux.Handle("POST /api/login/2fa", cfg.rateLimit(rateLimitAuth, cfg.handlerLoginMFA))
+	mux.Handle("GET /api/oidc/login", cfg.rateLimit(rateLimitAuth, cfg.handlerOIDCLogin))
+	mux.Handle("GET /api/oidc/callback", cfg.rateLimit(rateLimitAuth, cfg.handlerOIDCCallback))
+	mux.Handle("POST /api/oidc/session", cfg.rateLimit(rateLimitAuth, cfg.handlerOIDCSession))
+	mux.Handle("POST /api/refresh", cfg.rateLimit(rateLimitAuth, cfg.handlerRefresh))
+	mux.Handle("POST /api/revoke", cfg.rateLimit(rateLimitAuth, cfg.handlerRevoke))
+	mux.Handle("POST /api/tokens", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAuth, cfg.handlerTokenCreate)))
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.Handle("POST /api/users", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersCreate))
+	mux.Handle("POST /api/users/verify_email", cfg.rateLimit(rateLimitAuth, cfg.handlerEmailVerify))
+	mux.Handle("POST /api/users/verify_email/resend", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAuth, cfg.handlerEmailVerifyResend)))
+	mux.Handle("GET /api/users/me", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerUserMeGet)))
+	mux.Handle("PATCH /api/users/me", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerUserMeUpdate)))
+	mux.Handle("POST /api/users/me/reauth_code", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerReauthCodeSend)))
+	mux.Handle("DELETE /api/users/me", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerUserMeDelete)))
+	mux.Handle("POST /api/users/me/password", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerUserPasswordChange)))
+	mux.Handle("GET /api/users/me/usage", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerUserUsage)))
+	mux.Handle("GET /api/users/me/2fa", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerTwoFactorRetrieve)))
+	mux.Handle("POST /api/users/me/2fa", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerTwoFactorEnroll)))
+	mux.Handle("POST /api/users/me/2fa/confirm", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerTwoFactorConfirm)))
+	mux.Handle("POST /api/users/me/2fa/recovery_codes", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerRecoveryCodesRegenerate)))
+	mux.Handle("DELETE /api/users/me/2fa", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAuth, cfg.handlerTwoFactorDisable)))
+	mux.Handle("POST /api/password_reset", cfg.rateLimit(rateLimitAuth, cfg.handlerPasswordResetRequest))
+	mux.Handle("POST /api/password_reset/confirm", cfg.rateLimit(rateLimitAuth, cfg.handlerPasswordResetConfirm))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.Handle("POST /api/api_keys", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerAPIKeyCreate)))
+	mux.Handle("GET /api/api_keys", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerAPIKeysRetrieve)))
+	mux.Handle("DELETE /api/api_keys/{keyID}", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerAPIKeyRevoke)))
+
+	mux.Handle("GET /api/sessions", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerSessionsRetrieve)))
+	mux.Handle("DELETE /api/sessions", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerSessionsRevokeOthers)))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerSessionRevoke)))
+
+	mux.Handle("POST /api/videos", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoMetaCreate)))
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitUpload, cfg.handlerUploadThumbnail)))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitUpload, cfg.handlerUploadVideo)))
+	mux.Handle("GET /api/videos", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerVideosRetrieve)))
+	mux.Handle("GET /api/videos/shared", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerVideosSharedRetrieve)))
+	mux.Handle("GET /api/feed", cfg.rateLimit(rateLimitAPI, cfg.handlerVideosFeed))
+	mux.Handle("GET /api/videos/{videoID}", cfg.optionalAuthMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoGet)))
+	mux.Handle("PATCH /api/videos/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoMetaUpdate)))
+	mux.Handle("DELETE /api/videos/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoMetaDelete)))
+	mux.Handle("POST /api/videos/{videoID}/tags", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoTagsAdd)))
+	mux.Handle("DELETE /api/videos/{videoID}/tags/{tag}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoTagsRemove)))
+	mux.Handle("GET /api/videos/{videoID}/shares", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoSharesRetrieve)))
+	mux.Handle("PUT /api/videos/{videoID}/shares", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoShare)))
+	mux.Handle("DELETE /api/videos/{videoID}/shares/{userID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerVideoUnshare)))
+
+	mux.Handle("GET /api/tags", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerTagsSearch)))
+	mux.Handle("GET /api/tags/{tag}/videos", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerVideosByTag)))
+
+	mux.Handle("POST /api/playlists", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistCreate)))
+	mux.Handle("GET /api/playlists", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistsRetrieve)))
+	mux.Handle("GET /api/playlists/{playlistID}", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistGet)))
+	mux.Handle("PATCH /api/playlists/{playlistID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistUpdate)))
+	mux.Handle("DELETE /api/playlists/{playlistID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistDelete)))
+	mux.Handle("POST /api/playlists/{playlistID}/videos", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistVideoAdd)))
+	mux.Handle("PUT /api/playlists/{playlistID}/videos", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistReorder)))
+	mux.Handle("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerPlaylistVideoRemove)))
+
+	mux.Handle("POST /api/organizations", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationCreate)))
+	mux.Handle("GET /api/organizations", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationsRetrieve)))
+	mux.Handle("GET /api/organizations/{orgID}", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationGet)))
+	mux.Handle("GET /api/organizations/{orgID}/members", cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationMembersRetrieve)))
+	mux.Handle("PUT /api/organizations/{orgID}/members", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationMemberSet)))
+	mux.Handle("DELETE /api/organizations/{orgID}/members/{userID}", cfg.authMiddleware(auth.ScopeVideosWrite, cfg.rateLimit(rateLimitAPI, cfg.handlerOrganizationMemberRemove)))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.Handle("GET /admin/users", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminUsersRetrieve)))
+	mux.Handle("POST /admin/users/{userID}/disable", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminUserDisable)))
+	mux.Handle("POST /admin/users/{userID}/enable", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminUserEnable)))
+	mux.Handle("PUT /admin/users/{userID}/quota", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminUserQuotaSet)))
+	mux.Handle("POST /admin/users/{userID}/revoke_sessions", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminUserRevokeSessions)))
+	mux.Handle("GET /admin/videos/{videoID}", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminVideoGet)))
+	mux.Handle("DELETE /admin/videos/{videoID}", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminVideoDelete)))
+	mux.Handle("GET /admin/storage", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminStorage)))
+	mux.Handle("GET /admin/audit_log", cfg.adminMiddleware(cfg.rateLimit(rateLimitAPI, cfg.handlerAdminAuditLog)))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -138,3 +396,44 @@ func main() {
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// durationFromEnv reads an optional positive duration like "15m" from the
+// environment.
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s %q: must be a positive duration", key, value)
+	}
+	return d
+}
+
+// intFromEnv reads an optional positive integer from the environment.
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s %q: must be a positive integer", key, value)
+	}
+	return n
+}
+
+// int64FromEnv reads an optional positive 64-bit integer, such as a size in
+// bytes, from the environment.
+func int64FromEnv(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s %q: must be a positive integer", key, value)
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// newTestConfig returns a config backed by a fresh database in a temporary
+// directory. Tests set whatever else they need.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("Couldn't create database: %v", err)
+	}
+	cfKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Couldn't generate CloudFront key: %v", err)
+	}
+	return &apiConfig{
+		db:             db,
+		jwtKeys:        auth.NewKeyring(auth.NewHMACKey(auth.HMACKeyID, "test-secret")),
+		jwtAudience:    "tubely",
+		accessTokenTTL: time.Hour,
+		loginLimits: loginLimits{
+			MaxAccountFailures: 5,
+			MaxIPFailures:      20,
+			Window:             15 * time.Minute,
+			Lockout:            15 * time.Minute,
+		},
+		rateLimits: map[rateLimitClass]ratelimit.Limit{
+			rateLimitAuth:   {Requests: 20, Period: time.Minute},
+			rateLimitAPI:    {Requests: 600, Period: time.Minute},
+			rateLimitUpload: {Requests: 30, Period: time.Hour},
+		},
+		rateLimitStore:   ratelimit.NewMemoryStore(),
+		mailer:           mailer.LogMailer{},
+		s3CfDistribution: "cdn.tubely.test",
+		cfURLSigner:      sign.NewURLSigner("KTESTKEYPAIR", cfKey),
+	}
+}
+
+// doJSON sends body, if any, as JSON to handler with the given bearer token,
+// if any, and returns the recorded response.
+func doJSON(t *testing.T, handler http.Handler, method, target, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Couldn't encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// signUpAndLogIn creates an account through the API and returns the access
+// token of a new login session for it.
+func signUpAndLogIn(t *testing.T, cfg *apiConfig, email, password string) string {
+	t.Helper()
+	credentials := map[string]string{"email": email, "password": password}
+	if rec := doJSON(t, http.HandlerFunc(cfg.handlerUsersCreate), "POST", "/api/users", "", credentials); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got status %d: %s", rec.Code, rec.Body)
+	}
+	rec := doJSON(t, http.HandlerFunc(cfg.handlerLogin), "POST", "/api/login", "", credentials)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got status %d: %s", rec.Code, rec.Body)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &login); err != nil {
+		t.Fatalf("login: couldn't decode response: %v", err)
+	}
+	return login.Token
+}
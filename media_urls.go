@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoURL is where the video stored in S3 under key is served from. The
+// distribution turns it away until withMediaURLs has signed it.
+func (cfg *apiConfig) videoURL(key string) string {
+	return fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
+}
+
+// thumbnailURL is where the thumbnail stored under key in the assets
+// directory is served from.
+func (cfg *apiConfig) thumbnailURL(key string) string {
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, key)
+}
+
+// withMediaURLs returns the video with the media URLs to hand to a caller who
+// may view it. The CloudFront distribution only serves videos through signed
+// URLs, so every video gets one that expires after the media URL TTL, and
+// links to private videos stop working once they've leaked or access has been
+// taken away. Thumbnails of public videos keep their permanent URLs; others
+// get expiring ones too.
+func (cfg *apiConfig) withMediaURLs(video database.Video) (database.Video, error) {
+	expiresAt := time.Now().Add(cfg.mediaURLTTL)
+
+	if video.VideoURL != nil && strings.HasPrefix(*video.VideoURL, "https://"+cfg.s3CfDistribution+"/") {
+		signed, err := cfg.cfURLSigner.Sign(*video.VideoURL, expiresAt)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't sign video URL of %s: %w", video.ID, err)
+		}
+		video.VideoURL = &signed
+	}
+
+	if video.ThumbnailURL != nil && video.Visibility != database.VisibilityPublic {
+		_, key, ok := strings.Cut(*video.ThumbnailURL, "/assets/")
+		if ok {
+			signed := cfg.thumbnailURL(key) + "?" + url.Values{
+				"expires":   {strconv.FormatInt(expiresAt.Unix(), 10)},
+				"signature": {cfg.signAsset(key, expiresAt.Unix())},
+			}.Encode()
+			video.ThumbnailURL = &signed
+		}
+	}
+	return video, nil
+}
+
+// withMediaURLsAll is withMediaURLs for a list of videos.
+func (cfg *apiConfig) withMediaURLsAll(videos []database.Video) ([]database.Video, error) {
+	signed := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		video, err := cfg.withMediaURLs(video)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, video)
+	}
+	return signed, nil
+}
+
+// respondWithVideo responds with the video and media URLs for the caller.
+func (cfg *apiConfig) respondWithVideo(w http.ResponseWriter, r *http.Request, code int, video database.Video) {
+	video, err := cfg.withMediaURLs(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, code, video)
+}
+
+// respondWithVideos responds with the videos and media URLs for the caller.
+func (cfg *apiConfig) respondWithVideos(w http.ResponseWriter, r *http.Request, videos []database.Video) {
+	videos, err := cfg.withMediaURLsAll(videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// signAsset returns the signature that lets the asset stored under key be
+// fetched until expires, a Unix time.
+func (cfg *apiConfig) signAsset(key string, expires int64) string {
+	mac := hmac.New(sha256.New, cfg.mediaURLKey)
+	fmt.Fprintf(mac, "%s\n%d", key, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedAssetsMiddleware serves thumbnails of public videos to anyone, and
+// others only through an unexpired URL from withMediaURLs. Requests are
+// expected to have had the /assets prefix stripped.
+func (cfg *apiConfig) signedAssetsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err == nil && time.Now().Unix() < expires &&
+			hmac.Equal([]byte(query.Get("signature")), []byte(cfg.signAsset(key, expires))) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		public, err := cfg.db.IsPublicThumbnail(cfg.thumbnailURL(key))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check asset", err)
+			return
+		}
+		if !public {
+			// the same response as for missing files, so keys can't be probed
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
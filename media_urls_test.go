@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestThumbnailURLsOfNonPublicVideosAreSigned(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.port = "8091"
+	cfg.assetsRoot = t.TempDir()
+	cfg.mediaURLKey = []byte("test-media-key")
+	cfg.mediaURLTTL = time.Minute
+	assets := http.StripPrefix("/assets", cfg.signedAssetsMiddleware(http.FileServer(http.Dir(cfg.assetsRoot))))
+
+	signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "private",
+		Visibility: database.VisibilityPrivate,
+		UserID:     alice.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.assetsRoot, "thumb.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.thumbnailURL("thumb.png")
+	video.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(rawURL string) int {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		assets.ServeHTTP(rec, httptest.NewRequest("GET", u.RequestURI(), nil))
+		return rec.Code
+	}
+
+	signed, err := cfg.withMediaURLs(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := url.Parse(*signed.ThumbnailURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := tampered.Query()
+	query.Set("expires", "9999999999")
+	tampered.RawQuery = query.Encode()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"stored URL", thumbnailURL, http.StatusNotFound},
+		{"signed URL", *signed.ThumbnailURL, http.StatusOK},
+		{"tampered expiry", tampered.String(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fetch(tt.url); got != tt.status {
+				t.Errorf("got status %d, want %d", got, tt.status)
+			}
+		})
+	}
+
+	video.Visibility = database.VisibilityPublic
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	public, err := cfg.withMediaURLs(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *public.ThumbnailURL != thumbnailURL {
+		t.Errorf("public video got thumbnail URL %s, want the permanent %s", *public.ThumbnailURL, thumbnailURL)
+	}
+	if got := fetch(thumbnailURL); got != http.StatusOK {
+		t.Errorf("public thumbnail: got status %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestVideoURLsAreSigned(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.mediaURLTTL = time.Minute
+	token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	alice, err := cfg.db.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "private",
+		Visibility: database.VisibilityPrivate,
+		UserID:     alice.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	permanentURL := cfg.videoURL("landscape/abc-1.mp4")
+	video.VideoURL = &permanentURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/videos", cfg.authMiddleware(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.Handle("GET /api/videos/{videoID}", cfg.optionalAuthMiddleware(auth.ScopeVideosRead, cfg.handlerVideoGet))
+	for _, target := range []string{"/api/videos", "/api/videos/" + video.ID.String()} {
+		t.Run(target, func(t *testing.T) {
+			rec := doJSON(t, mux, "GET", target, token, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), `"`+permanentURL+`"`) {
+				t.Fatalf("response has the permanent video URL: %s", rec.Body)
+			}
+
+			var videos []database.Video
+			if target == "/api/videos" {
+				err = json.Unmarshal(rec.Body.Bytes(), &videos)
+			} else {
+				videos = make([]database.Video, 1)
+				err = json.Unmarshal(rec.Body.Bytes(), &videos[0])
+			}
+			if err != nil || len(videos) != 1 || videos[0].VideoURL == nil {
+				t.Fatalf("couldn't find the video in %s: %v", rec.Body, err)
+			}
+			signed, err := url.Parse(*videos[0].VideoURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := signed.Query()
+			expires, err := strconv.ParseInt(query.Get("Expires"), 10, 64)
+			if err != nil || expires > time.Now().Add(cfg.mediaURLTTL).Unix() {
+				t.Errorf("got Expires %q, want a time within the media URL TTL", query.Get("Expires"))
+			}
+			if query.Get("Signature") == "" || query.Get("Key-Pair-Id") != "KTESTKEYPAIR" {
+				t.Errorf("got video URL %s, want one signed for CloudFront", signed)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	UserID uuid.UUID
+	Email  string
+	Role   database.UserRole
+	// APIKeyID is set when the caller authenticated with an API key rather
+	// than a login session.
+	APIKeyID *uuid.UUID
+	// SessionID is the login session an access token was issued for.
+	SessionID *uuid.UUID
+	// Delegated is set for tokens minted for an integration. They carry their
+	// session's ID but can't act as the session itself.
+	Delegated bool
+	// Scopes lists what the credential may be used for.
+	Scopes []string
+}
+
+func (p principal) hasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// sessionScopes are granted to users who logged in with their password.
+var sessionScopes = []string{auth.ScopeVideosRead, auth.ScopeVideosWrite, auth.ScopeAdmin}
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+var errAccountDisabled = errors.New("account is disabled")
+
+// authenticate validates the credentials on a request, accepting either a
+// "Bearer <JWT>" or an "ApiKey <key>" authorization header.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	var p principal
+	if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		key, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			return principal{}, err
+		}
+		apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+		if err != nil {
+			return principal{}, err
+		}
+		if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+			return principal{}, errors.New("invalid API key")
+		}
+		if err := cfg.db.TouchAPIKey(apiKey.ID); err != nil {
+			return principal{}, err
+		}
+		p = principal{
+			UserID:   apiKey.UserID,
+			APIKeyID: &apiKey.ID,
+			Scopes:   apiKey.Scopes,
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return principal{}, err
+		}
+		accessToken, err := auth.ValidateJWT(token, cfg.jwtKeys, cfg.jwtAudience)
+		if err != nil {
+			return principal{}, err
+		}
+		p = principal{
+			UserID:    accessToken.UserID,
+			Scopes:    accessToken.Scopes,
+			Delegated: accessToken.Delegated,
+		}
+		if accessToken.SessionID != uuid.Nil {
+			// revoking a session also cuts off the access tokens issued for it
+			active, err := cfg.db.SessionActive(accessToken.SessionID)
+			if err != nil {
+				return principal{}, err
+			}
+			if !active {
+				return principal{}, errors.New("session has been revoked")
+			}
+			p.SessionID = &accessToken.SessionID
+		}
+	}
+
+	user, err := cfg.db.GetUser(p.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+	if user == nil {
+		return principal{}, errors.New("user no longer exists")
+	}
+	if user.DisabledAt != nil {
+		return principal{}, errAccountDisabled
+	}
+	p.Email = user.Email
+	p.Role = user.Role
+	return p, nil
+}
+
+// authMiddleware rejects requests without valid credentials carrying scope,
+// and makes the caller available to next through principalFromContext.
+func (cfg *apiConfig) authMiddleware(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if errors.Is(err, errAccountDisabled) {
+			respondWithError(w, http.StatusForbidden, "Account is disabled", err)
+			return
+		}
+		if err != nil {
+			// rate limits inside only see authenticated requests, so charge
+			// failures to the client's auth budget, as for a failed login
+			if !cfg.takeRateLimit(w, r, rateLimitAuth, clientRateLimitKey(rateLimitAuth, r)) {
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+			return
+		}
+		if !p.hasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Credentials lack the "+scope+" scope", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalContextKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// optionalAuthMiddleware is like authMiddleware, but lets requests without an
+// Authorization header through anonymously. Bad credentials are still rejected.
+func (cfg *apiConfig) optionalAuthMiddleware(scope string, next http.HandlerFunc) http.Handler {
+	authenticated := cfg.authMiddleware(scope, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// adminMiddleware requires credentials with the admin scope that belong to an
+// admin account.
+func (cfg *apiConfig) adminMiddleware(next http.HandlerFunc) http.Handler {
+	return cfg.authMiddleware(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFromContext(r.Context())
+		if p.Role != database.UserRoleAdmin {
+			respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// principalFromContext returns the caller placed in the context by
+// authMiddleware.
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(principal)
+	return p, ok
+}
+
+// requireSession returns the caller if they authenticated with a login
+// session, responding with an error and returning false otherwise. Account
+// settings, sessions and API keys can't be managed with API keys or tokens
+// minted for integrations.
+func requireSession(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return principal{}, false
+	}
+	if caller.SessionID == nil || caller.Delegated {
+		respondWithError(w, http.StatusForbidden, "This requires a login session", nil)
+		return principal{}, false
+	}
+	return caller, true
+}
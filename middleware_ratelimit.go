@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// rateLimitClass groups routes that share a rate limit.
+type rateLimitClass string
+
+const (
+	// rateLimitAuth covers logins, signups and other endpoints that check
+	// credentials or send email, which are worth brute-forcing or abusing.
+	rateLimitAuth rateLimitClass = "auth"
+	// rateLimitAPI covers reading and editing metadata.
+	rateLimitAPI rateLimitClass = "api"
+	// rateLimitUpload covers media uploads, which are expensive to process.
+	rateLimitUpload rateLimitClass = "upload"
+)
+
+// rateLimit limits how often each user, or each client address for
+// anonymous requests, can call next. Routes in the same class share a
+// budget. It goes inside the auth middleware so that it can see the user;
+// requests that fail authentication are charged by the auth middleware.
+func (cfg *apiConfig) rateLimit(class rateLimitClass, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := clientRateLimitKey(class, r)
+		if caller, ok := principalFromContext(r.Context()); ok {
+			key = string(class) + ":user:" + caller.UserID.String()
+		}
+		if !cfg.takeRateLimit(w, r, class, key) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientRateLimitKey is the bucket for class that anonymous requests from
+// r's client address spend.
+func clientRateLimitKey(class rateLimitClass, r *http.Request) string {
+	return string(class) + ":ip:" + clientIP(r)
+}
+
+// takeRateLimit spends a request from key's bucket for class and sets the
+// rate limit headers. It responds with 429 and returns false if the bucket
+// is empty.
+func (cfg *apiConfig) takeRateLimit(w http.ResponseWriter, r *http.Request, class rateLimitClass, key string) bool {
+	limit := cfg.rateLimits[class]
+	result, err := cfg.rateLimitStore.Take(r.Context(), key, limit, time.Now())
+	if err != nil {
+		// a shared store going down shouldn't take the API with it
+		log.Printf("Couldn't check rate limit for %s: %v", key, err)
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Period.Seconds())))
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", retryAfterSeconds(result.ResetAfter))
+	if !result.Allowed {
+		header.Set("Retry-After", retryAfterSeconds(result.RetryAfter))
+		respondWithError(w, http.StatusTooManyRequests, "Too many requests; try again later", nil)
+		return false
+	}
+	return true
+}
+
+// retryAfterSeconds formats d as whole seconds for Retry-After style headers,
+// rounding up so clients don't retry too early.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitFromEnv reads an optional limit like "100/1m" from the
+// environment.
+func rateLimitFromEnv(key string, fallback ratelimit.Limit) ratelimit.Limit {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return limit
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestFailedAuthenticationIsRateLimited(t *testing.T) {
+	cfg := newTestConfig(t)
+	token := signUpAndLogIn(t, cfg, "alice@example.com", "correct horse")
+	handler := cfg.authMiddleware(auth.ScopeVideosRead, cfg.rateLimit(rateLimitAPI, cfg.handlerUserMeGet))
+
+	// signing up and logging in didn't go through the auth rate limit, so
+	// the whole budget is left for guessing
+	limit := cfg.rateLimits[rateLimitAuth].Requests
+	for i := range limit {
+		if rec := doJSON(t, handler, "GET", "/api/users/me", "not-a-token", nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got status %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	rec := doJSON(t, handler, "GET", "/api/users/me", "not-a-token", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d once the budget was spent, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("got no Retry-After header")
+	}
+
+	// valid credentials are limited per user, not by the failures
+	if rec := doJSON(t, handler, "GET", "/api/users/me", token, nil); rec.Code != http.StatusOK {
+		t.Errorf("got status %d with a valid token, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// storageQuota limits how much media a user can store. Videos count against
+// their owner, whichever workspace they're in.
+type storageQuota struct {
+	Bytes  int64 `json:"bytes"`
+	Videos int   `json:"videos"`
+}
+
+// quotaFor returns the user's own quota where an admin has set one, and the
+// default otherwise.
+func (cfg *apiConfig) quotaFor(user database.User) storageQuota {
+	quota := cfg.defaultQuota
+	if user.QuotaBytes != nil {
+		quota.Bytes = *user.QuotaBytes
+	}
+	if user.QuotaVideos != nil {
+		quota.Videos = *user.QuotaVideos
+	}
+	return quota
+}
+
+// checkStorageQuota checks that the owner can store addBytes more bytes and
+// addVideos more videos, responding with an error and returning false if
+// not. addBytes is negative when an upload replaces larger media.
+func (cfg *apiConfig) checkStorageQuota(w http.ResponseWriter, ownerID uuid.UUID, addBytes int64, addVideos int) bool {
+	owner, err := cfg.db.GetUser(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video owner", err)
+		return false
+	}
+	if owner == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video owner", nil)
+		return false
+	}
+	usage, err := cfg.db.GetStorageUsage(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+
+	quota := cfg.quotaFor(*owner)
+	if addVideos > 0 && usage.Videos+addVideos > quota.Videos {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Video quota of %d videos exceeded", quota.Videos), nil)
+		return false
+	}
+	if addBytes > 0 && usage.Bytes+addBytes > quota.Bytes {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Storage quota of %d bytes exceeded", quota.Bytes), nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerUserUsage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Usage database.StorageUsage `json:"usage"`
+		Quota storageQuota          `json:"quota"`
+	}
+
+	caller, ok := principalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", nil)
+		return
+	}
+	user, ok := cfg.getCurrentUser(w, caller)
+	if !ok {
+		return
+	}
+	usage, err := cfg.db.GetStorageUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Usage: usage,
+		Quota: cfg.quotaFor(user),
+	})
+}
+
+// handlerAdminUserQuotaSet overrides a user's quota. Omitted or null limits
+// go back to the default.
+func (cfg *apiConfig) handlerAdminUserQuotaSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Bytes  *int64 `json:"bytes"`
+		Videos *int   `json:"videos"`
+	}
+
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Bytes != nil && *params.Bytes < 0 || params.Videos != nil && *params.Videos < 0 {
+		respondWithError(w, http.StatusBadRequest, "Quotas can't be negative", nil)
+		return
+	}
+
+	if err := cfg.db.SetUserQuota(user.ID, params.Bytes, params.Videos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set quota", err)
+		return
+	}
+	user.QuotaBytes = params.Bytes
+	user.QuotaVideos = params.Videos
+	quota := cfg.quotaFor(user)
+	cfg.auditAdminAction(admin, "set_quota", "user:"+user.ID.String(), fmt.Sprintf("%d bytes, %d videos", quota.Bytes, quota.Videos))
+
+	respondWithJSON(w, http.StatusOK, quota)
+}
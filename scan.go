@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// scanUpload scans an uploaded file before it's stored. kind is "video" or
+// "thumbnail". If the scanner flags the file, it's moved to the quarantine
+// directory, the video is marked rejected, and the upload is refused. It
+// responds with an error and returns false unless the file is clean.
+func (cfg *apiConfig) scanUpload(w http.ResponseWriter, r *http.Request, video database.Video, kind string, file io.ReadSeeker, hash []byte) bool {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rewind uploaded file", err)
+		return false
+	}
+	result, err := cfg.scanner.Scan(r.Context(), file)
+	if err != nil {
+		// refuse what we couldn't check rather than store it unscanned
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't scan uploaded file; try again later", err)
+		return false
+	}
+	if !result.Infected {
+		return true
+	}
+
+	reason := fmt.Sprintf("%s upload flagged by malware scan: %s", kind, result.Signature)
+	name := fmt.Sprintf("%s-%s-%x", video.ID, kind, hash)
+	if err := cfg.quarantineUpload(name, file); err != nil {
+		log.Printf("Couldn't quarantine %s of video %s: %v", kind, video.ID, err)
+	}
+
+	caller, _ := principalFromContext(r.Context())
+	err = cfg.db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+		ActorID: &caller.UserID,
+		Action:  "upload_rejected",
+		Target:  "video:" + video.ID.String(),
+		Detail:  reason + "; quarantined as " + name,
+	})
+	if err != nil {
+		log.Printf("Couldn't record rejected upload of video %s: %v", video.ID, err)
+	}
+
+	video.Status = database.VideoStatusRejected
+	video.RejectionReason = &reason
+	video.UpdatedAt = time.Now()
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+
+	respondWithError(w, http.StatusUnprocessableEntity, "Upload rejected: "+reason, nil)
+	return false
+}
+
+// quarantineUpload keeps a copy of a flagged file for review, readable only
+// by the server.
+func (cfg *apiConfig) quarantineUpload(name string, file io.ReadSeeker) error {
+	if err := os.MkdirAll(cfg.quarantineDir, 0o700); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(filepath.Join(cfg.quarantineDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		// the same file was already flagged for this video
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, file); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
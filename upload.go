@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// uploadLimits caps the request body of each upload endpoint, in bytes.
+type uploadLimits struct {
+	Video     int64
+	Thumbnail int64
+}
+
+// uploadedFile describes a file part copied out of an upload.
+type uploadedFile struct {
+	Size   int64
+	MD5    []byte
+	SHA256 []byte
+}
+
+// uploadChecksums are digests of the uploaded file that the client sent in
+// the Content-MD5 and X-Content-SHA256 headers. Either can be missing. They
+// cover the file part, not the whole multipart body.
+type uploadChecksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// parseUploadChecksums reads the checksum headers, responding with an error
+// and returning false if they're malformed. Content-MD5 is base64, as in RFC
+// 1864, and X-Content-SHA256 is hex or base64.
+func parseUploadChecksums(w http.ResponseWriter, r *http.Request) (uploadChecksums, bool) {
+	var checksums uploadChecksums
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			respondWithError(w, http.StatusBadRequest, "Content-MD5 header must be a base64 MD5 digest", err)
+			return uploadChecksums{}, false
+		}
+		checksums.MD5 = sum
+	}
+	if value := r.Header.Get("X-Content-SHA256"); value != "" {
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			sum, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			respondWithError(w, http.StatusBadRequest, "X-Content-SHA256 header must be a hex or base64 SHA-256 digest", err)
+			return uploadChecksums{}, false
+		}
+		checksums.SHA256 = sum
+	}
+	return checksums, true
+}
+
+// verify checks the uploaded file against the checksums the client sent,
+// responding with an error and returning false on a mismatch.
+func (c uploadChecksums) verify(w http.ResponseWriter, uploaded uploadedFile) bool {
+	if c.MD5 != nil && !bytes.Equal(c.MD5, uploaded.MD5) {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't match its Content-MD5 header; it may have been corrupted in transit", nil)
+		return false
+	}
+	if c.SHA256 != nil && !bytes.Equal(c.SHA256, uploaded.SHA256) {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't match its X-Content-SHA256 header; it may have been corrupted in transit", nil)
+		return false
+	}
+	return true
+}
+
+// openUploadPart limits the request body to limit bytes and reads the
+// multipart form up to the file part named field, without buffering the
+// form. It responds with an error and returns false if there's no such
+// part. Earlier parts are skipped.
+func openUploadPart(w http.ResponseWriter, r *http.Request, field string, limit int64) (*multipart.Part, bool) {
+	if r.ContentLength > limit {
+		respondWithUploadTooLarge(w, limit, nil)
+		return nil, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read multipart form", err)
+		return nil, false
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Couldn't get %s file", field), nil)
+			return nil, false
+		}
+		if err != nil {
+			if isUploadTooLarge(err) {
+				respondWithUploadTooLarge(w, limit, err)
+				return nil, false
+			}
+			respondWithError(w, http.StatusBadRequest, "Couldn't read multipart form", err)
+			return nil, false
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, true
+		}
+	}
+}
+
+// copyUploadPart streams part into dst, hashing it on the way. It responds
+// with an error and returns false if the copy fails, including when the
+// body goes over its limit.
+func copyUploadPart(w http.ResponseWriter, dst io.Writer, part *multipart.Part, limit int64) (uploadedFile, bool) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, md5Hash, sha256Hash), part)
+	if err != nil {
+		if isUploadTooLarge(err) {
+			respondWithUploadTooLarge(w, limit, err)
+			return uploadedFile{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read uploaded file", err)
+		return uploadedFile{}, false
+	}
+	return uploadedFile{Size: n, MD5: md5Hash.Sum(nil), SHA256: sha256Hash.Sum(nil)}, true
+}
+
+func isUploadTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func respondWithUploadTooLarge(w http.ResponseWriter, limit int64, err error) {
+	respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload is larger than the limit of %d bytes", limit), err)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoPermission is the level of access a user has to a video. Each level
+// includes the ones below it.
+type videoPermission int
+
+const (
+	permissionNone videoPermission = iota
+	// permissionView allows seeing the video and its media URLs.
+	permissionView
+	// permissionEdit allows changing metadata and uploading media.
+	permissionEdit
+	// permissionOwner allows deleting, sharing and changing visibility.
+	permissionOwner
+)
+
+// videoPermission works out what userID (uuid.Nil for anonymous requests) may
+// do with the video. Every handler that returns or changes a video must check
+// this first.
+func (cfg *apiConfig) videoPermission(video database.Video, userID uuid.UUID) (videoPermission, error) {
+	if userID != uuid.Nil && video.OrganizationID != nil {
+		role, err := cfg.db.GetOrganizationRole(*video.OrganizationID, userID)
+		if err != nil {
+			return permissionNone, err
+		}
+		switch {
+		case role.AtLeast(database.OrgRoleAdmin):
+			return permissionOwner, nil
+		case role == database.OrgRoleMember && video.UserID == userID:
+			return permissionOwner, nil
+		case role == database.OrgRoleMember:
+			return permissionEdit, nil
+		case role == database.OrgRoleViewer:
+			return permissionView, nil
+		}
+	}
+
+	if userID != uuid.Nil {
+		// uploaders who have left an organization no longer own its videos
+		if video.UserID == userID && video.OrganizationID == nil {
+			return permissionOwner, nil
+		}
+
+		role, err := cfg.db.GetVideoShareRole(video.ID, userID)
+		if err != nil {
+			return permissionNone, err
+		}
+		switch role {
+		case database.ShareRoleEditor:
+			return permissionEdit, nil
+		case database.ShareRoleViewer:
+			return permissionView, nil
+		}
+	}
+
+	if video.Visibility == database.VisibilityUnlisted || video.Visibility == database.VisibilityPublic {
+		return permissionView, nil
+	}
+	return permissionNone, nil
+}
+
+// getVideoWithPermission loads a video and checks that userID has at least the
+// required permission on it, responding with an error and returning false
+// otherwise. Videos the user can't see at all are reported as missing so their
+// IDs can't be probed.
+func (cfg *apiConfig) getVideoWithPermission(w http.ResponseWriter, videoID, userID uuid.UUID, required videoPermission) (database.Video, bool) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return database.Video{}, false
+	}
+
+	permission, err := cfg.videoPermission(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return database.Video{}, false
+	}
+	if permission == permissionNone {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return database.Video{}, false
+	}
+	if permission < required {
+		respondWithError(w, http.StatusForbidden, "You don't have permission to do that to this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// workspaceHeader selects the organization a request operates on. Requests
+// without it operate on the caller's personal library.
+const workspaceHeader = "X-Workspace-ID"
+
+// workspace is the library a request is scoped to.
+type workspace struct {
+	// OrganizationID is nil for the caller's personal library.
+	OrganizationID *uuid.UUID
+	// Role is the caller's role in the organization; personal libraries are
+	// always owned by the caller.
+	Role database.OrgRole
+}
+
+// getActiveWorkspace resolves the workspace selected by the request and checks
+// that userID belongs to it, responding with an error and returning false
+// otherwise.
+func (cfg *apiConfig) getActiveWorkspace(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (workspace, bool) {
+	header := r.Header.Get(workspaceHeader)
+	if header == "" {
+		return workspace{Role: database.OrgRoleOwner}, true
+	}
+
+	orgID, err := uuid.Parse(header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID", err)
+		return workspace{}, false
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get workspace membership", err)
+		return workspace{}, false
+	}
+	if role == "" {
+		respondWithError(w, http.StatusForbidden, "You aren't a member of this workspace", nil)
+		return workspace{}, false
+	}
+	return workspace{OrganizationID: &orgID, Role: role}, true
+}
+
+// contains reports whether the video belongs to the workspace.
+func (ws workspace) contains(video database.Video) bool {
+	if ws.OrganizationID == nil || video.OrganizationID == nil {
+		return ws.OrganizationID == nil && video.OrganizationID == nil
+	}
+	return *ws.OrganizationID == *video.OrganizationID
+}
+
+// storagePrefix returns the prefix under which a workspace's media is stored,
+// so each organization's objects can be managed and accounted for separately.
+func storagePrefix(organizationID *uuid.UUID) string {
+	if organizationID == nil {
+		return ""
+	}
+	return "workspaces/" + organizationID.String() + "/"
+}